@@ -1,6 +1,9 @@
 package bootstrap
 
 import (
+	"context"
+	"errors"
+
 	"github.com/yaron8/telemetry-infra/generator/config"
 	"github.com/yaron8/telemetry-infra/generator/metrics"
 	"github.com/yaron8/telemetry-infra/generator/service"
@@ -33,8 +36,34 @@ func NewBootstrap() (*Bootstrap, error) {
 	}, nil
 }
 
-func (b *Bootstrap) Start() error {
+// Start serves the API until the context is cancelled, then drains the in-flight requests
+// within the shutdown timeout and flushes the logs
+func (b *Bootstrap) Start(ctx context.Context) error {
 	logger := logi.GetLogger()
 	logger.Info("Bootstrap is starting")
-	return b.apiServer.Start()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- b.apiServer.Start()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return errors.Join(err, logi.Close())
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutdown signal received, shutting down", "timeout", b.config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), b.config.ShutdownTimeout)
+	defer cancel()
+
+	err := errors.Join(b.apiServer.Shutdown(shutdownCtx), <-serveErr)
+	if err != nil {
+		logger.Error("Shutdown failed", "error", err)
+	} else {
+		logger.Info("Shutdown complete")
+	}
+
+	// Flush the logs last, nothing is logged afterwards
+	return errors.Join(err, logi.Close())
 }
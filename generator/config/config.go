@@ -1,17 +1,28 @@
 package config
 
 import (
+	"os"
 	"time"
 )
 
 type Config struct {
-	Port        int           // Port
-	SnapshotTTL time.Duration // Snapshot TTL
+	Port            int           // Port
+	SnapshotTTL     time.Duration // Snapshot TTL
+	ShutdownTimeout time.Duration // How long in-flight requests get to finish on shutdown
 }
 
 func NewConfig() *Config {
+	// Read the shutdown timeout from environment variable, default to 20 seconds
+	shutdownTimeout := 20 * time.Second
+	if shutdownTimeoutStr := os.Getenv("SHUTDOWN_TIMEOUT"); shutdownTimeoutStr != "" {
+		if timeout, err := time.ParseDuration(shutdownTimeoutStr); err == nil && timeout > 0 {
+			shutdownTimeout = timeout
+		}
+	}
+
 	return &Config{
-		Port:        9001,
-		SnapshotTTL: 10 * time.Second,
+		Port:            9001,
+		SnapshotTTL:     10 * time.Second,
+		ShutdownTimeout: shutdownTimeout,
 	}
 }
@@ -2,8 +2,11 @@ package integration_tests
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -155,3 +158,63 @@ func (s *IntegrationTestSuite) TestCountersEndpoint() {
 	assert.True(s.T(), got304, "Expected at least one 304 Not Modified response")
 	assert.True(s.T(), csvValidated, "Expected to validate CSV structure and data types from 200 response")
 }
+
+// TestInternalMetricsEndpoint tests the /internal/metrics endpoint reports the served requests
+func (s *IntegrationTestSuite) TestInternalMetricsEndpoint() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(generatorBaseURL + "/counters")
+	s.Require().NoError(err, "Failed to make request to /counters endpoint")
+	resp.Body.Close()
+
+	// The request is recorded once its handler returns, which may be after the response was read
+	body := ""
+	assert.Eventually(s.T(), func() bool {
+		resp, err := client.Get(generatorBaseURL + "/internal/metrics")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false
+		}
+		body = string(bodyBytes)
+		return strings.Contains(body, `http_requests_total{route="/counters"`)
+	}, 2*time.Second, 100*time.Millisecond, "Expected request count of /counters")
+
+	assert.Contains(s.T(), body, `http_requests_total{route="/counters",status=`, "Expected request count of /counters")
+	assert.Contains(s.T(), body, "# TYPE http_request_duration_seconds histogram", "Expected request latency histogram")
+	assert.Contains(s.T(), body, "# TYPE generator_snapshot_generation_duration_seconds histogram", "Expected snapshot generation histogram")
+}
+
+// TestProbeEndpoints tests the /livez and /readyz endpoints report the service as live and ready
+func (s *IntegrationTestSuite) TestProbeEndpoints() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	for _, path := range []string{"/livez", "/readyz"} {
+		resp, err := client.Get(generatorBaseURL + path)
+		s.Require().NoError(err, "Failed to make request to %s endpoint", path)
+
+		var report struct {
+			Status string `json:"status"`
+			Checks map[string]struct {
+				Status string `json:"status"`
+			} `json:"checks"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		s.Require().NoError(err, "Failed to parse JSON response of %s", path)
+
+		assert.Equal(s.T(), http.StatusOK, resp.StatusCode, "Expected status code 200 for %s", path)
+		assert.Equal(s.T(), "ok", report.Status, "Expected ok status for %s", path)
+		if path == "/readyz" {
+			assert.Equal(s.T(), "ok", report.Checks["snapshot"].Status, "Expected the snapshot check to pass")
+		}
+	}
+}
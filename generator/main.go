@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
 
 	"github.com/yaron8/telemetry-infra/generator/bootstrap"
 )
@@ -12,7 +15,11 @@ func main() {
 		log.Fatalf("Failed to create bootstrap: %v", err)
 	}
 
-	if err := bootstrap.Start(); err != nil {
+	// Shut down gracefully on Ctrl+C and on the SIGTERM sent by Docker and Kubernetes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := bootstrap.Start(ctx); err != nil {
 		panic(err)
 	}
 }
@@ -10,12 +10,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/yaron8/telemetry-infra/instrument"
 	"github.com/yaron8/telemetry-infra/logi"
 	"github.com/yaron8/telemetry-infra/telemetrics"
 )
 
 const numOfDataLines = 100
 
+var snapshotGenerationDuration = instrument.NewHistogramVec("generator_snapshot_generation_duration_seconds",
+	"Time in seconds to generate a new CSV snapshot.", instrument.DefBuckets)
+
 type CSVMetrics struct {
 	mu                      sync.RWMutex
 	snapshotLastTimeUpdated time.Time
@@ -62,7 +66,38 @@ func (cm *CSVMetrics) GetCSVMetrics() (*CSVMetricsResponse, error) {
 
 	// Only log when actually generating new data (cold path)
 	cm.logger.Info("Generating new CSV metrics", "num_lines", numOfDataLines)
+	start := time.Now()
+
+	currTimestamp := time.Now().Unix()
+	snapshot, err := generateCSV(currTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save to snapshot
+	cm.snapshotLastTimeUpdated = time.Now()
+	snapshotGenerationDuration.Observe(time.Since(start).Seconds())
+
+	cm.logger.Info("CSV metrics generated successfully",
+		"data_size_bytes", len(snapshot),
+		"num_lines", numOfDataLines,
+		"timestamp", currTimestamp)
+
+	return &CSVMetricsResponse{
+		CSVData:          snapshot,
+		HTTPResponseCode: http.StatusOK,
+	}, nil
+}
+
+// CheckSnapshot generates a snapshot and discards it, to check one can be produced
+// The cached snapshot is left untouched, so the next GetCSVMetrics call is not affected
+func (cm *CSVMetrics) CheckSnapshot() error {
+	_, err := generateCSV(time.Now().Unix())
+	return err
+}
 
+// generateCSV generates a CSV snapshot of numOfDataLines switches for the given timestamp
+func generateCSV(timestamp int64) (string, error) {
 	// Create a buffer to write CSV data to
 	var buf bytes.Buffer
 	writer := csv.NewWriter(&buf)
@@ -70,16 +105,14 @@ func (cm *CSVMetrics) GetCSVMetrics() (*CSVMetricsResponse, error) {
 	// Write header
 	header := telemetrics.GetCSVHeader()
 	if err := writer.Write(header); err != nil {
-		return nil, fmt.Errorf("error writing header: %w", err)
+		return "", fmt.Errorf("error writing header: %w", err)
 	}
 
-	currTimestamp := time.Now().Unix()
-
 	// Generate numOfDataLines lines of data
 	for i := 1; i <= numOfDataLines; i++ {
 		// Generate random metrics data
 		metric := telemetrics.MetricRecord{
-			Timestamp:     currTimestamp,
+			Timestamp:     timestamp,
 			SwitchID:      fmt.Sprintf("sw%d", i),
 			BandwidthMbps: rand.Float64() * 10000, // Random bandwidth up to 10 Gbps
 			LatencyMs:     rand.Float64() * 5000,  // Random latency up to 5 seconds
@@ -95,27 +128,15 @@ func (cm *CSVMetrics) GetCSVMetrics() (*CSVMetricsResponse, error) {
 		}
 
 		if err := writer.Write(row); err != nil {
-			return nil, fmt.Errorf("error writing row: %w", err)
+			return "", fmt.Errorf("error writing row: %w", err)
 		}
 	}
 
 	// Flush the writer to ensure all data is written to the buffer
 	writer.Flush()
 	if err := writer.Error(); err != nil {
-		return nil, fmt.Errorf("error flushing writer: %w", err)
+		return "", fmt.Errorf("error flushing writer: %w", err)
 	}
 
-	// Save to snapshot
-	snapshot := buf.String()
-	cm.snapshotLastTimeUpdated = time.Now()
-
-	cm.logger.Info("CSV metrics generated successfully",
-		"data_size_bytes", len(snapshot),
-		"num_lines", numOfDataLines,
-		"timestamp", currTimestamp)
-
-	return &CSVMetricsResponse{
-		CSVData:          snapshot,
-		HTTPResponseCode: http.StatusOK,
-	}, nil
+	return buf.String(), nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -8,9 +9,14 @@ import (
 
 	"github.com/yaron8/telemetry-infra/generator/config"
 	"github.com/yaron8/telemetry-infra/generator/metrics"
+	"github.com/yaron8/telemetry-infra/health"
+	"github.com/yaron8/telemetry-infra/instrument"
 	"github.com/yaron8/telemetry-infra/logi"
 )
 
+// readinessCheckTimeout bounds every readiness check
+const readinessCheckTimeout = 2 * time.Second
+
 type APIServer struct {
 	csvMetrics *metrics.CSVMetrics
 	config     *config.Config
@@ -22,7 +28,13 @@ func NewAPIServer(config *config.Config, csvMetrics *metrics.CSVMetrics) *APISer
 	return &APIServer{
 		config:     config,
 		csvMetrics: csvMetrics,
-		logger:     logi.GetLogger(),
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", config.Port),
+			ReadTimeout:  60 * time.Second,
+			WriteTimeout: 60 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		logger: logi.GetLogger(),
 	}
 }
 
@@ -40,19 +52,25 @@ func (api *APIServer) Start() error {
 		}
 	})
 
+	// Liveness and readiness probes, ready once a snapshot can be produced
+	mux.Handle("/livez", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(readinessCheckTimeout, health.Check{
+		Name: "snapshot",
+		Run: func(ctx context.Context) error {
+			return api.csvMetrics.CheckSnapshot()
+		},
+	}))
+
 	// Set up HTTP handlers
 	mux.HandleFunc("/counters", api.countersHandler)
 
-	// Wrap the mux with logging middleware
+	// Service metrics in the Prometheus text format
+	mux.Handle("/internal/metrics", instrument.Handler())
+
+	// Wrap the mux with instrumentation middleware
 	handler := api.middleware(mux)
 
-	api.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", api.config.Port),
-		Handler:      handler,
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	api.server.Handler = handler
 
 	fmt.Printf("Starting server on port %d\n", api.config.Port)
 	if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -61,3 +79,15 @@ func (api *APIServer) Start() error {
 
 	return nil
 }
+
+// Shutdown stops accepting connections and waits for the in-flight requests to complete
+// until the context is done
+// Start returns once Shutdown is called
+func (api *APIServer) Shutdown(ctx context.Context) error {
+	api.logger.Info("APIServer shutting down")
+
+	if err := api.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+	return nil
+}
@@ -2,12 +2,12 @@ package service
 
 import (
 	"net/http"
+
+	"github.com/yaron8/telemetry-infra/instrument"
 )
 
-// middleware logs HTTP request details and records Prometheus metrics
+// middleware records the count, latency and in-flight number of requests per route and status,
+// exposed on /internal/metrics
 func (api *APIServer) middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+	return instrument.Middleware(next)
 }
@@ -0,0 +1,145 @@
+// Package health serves the liveness and readiness probes of the services
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a named readiness check, failing if Run returns an error
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the JSON body of a probe
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// redactedReport is the JSON body of a probe holding only the name and status of each check
+type redactedReport struct {
+	Status string                    `json:"status"`
+	Checks map[string]redactedResult `json:"checks,omitempty"`
+}
+
+type redactedResult struct {
+	Status string `json:"status"`
+}
+
+// LivenessHandler reports the process is up and serving requests
+// It checks no dependency, so an orchestrator never restarts a service for an outage of another one
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler runs the checks concurrently, each bounded by timeout, and reports the result
+// of every check
+// Responds 200 if every check passed, 503 otherwise
+func ReadinessHandler(timeout time.Duration, checks ...Check) http.Handler {
+	return RedactedReadinessHandler(timeout, nil, checks...)
+}
+
+// RedactedReadinessHandler is a ReadinessHandler reporting only the name and status of each check
+// to requests for which showDetails returns false, since the errors may reveal the addresses
+// and failures of the dependencies
+// A nil showDetails shows the details to every request
+func RedactedReadinessHandler(timeout time.Duration, showDetails func(r *http.Request) bool, checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Run(r.Context(), timeout, checks...)
+
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		if showDetails != nil && !showDetails(r) {
+			writeReport(w, status, report.redacted())
+			return
+		}
+		writeReport(w, status, report)
+	})
+}
+
+// Run runs the checks concurrently, each bounded by timeout, and returns their report
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runCheck(ctx, timeout, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// redacted returns the report with only the name and status of each check
+func (r Report) redacted() redactedReport {
+	redacted := redactedReport{
+		Status: r.Status,
+		Checks: make(map[string]redactedResult, len(r.Checks)),
+	}
+	for name, result := range r.Checks {
+		redacted.Checks[name] = redactedResult{Status: result.Status}
+	}
+	return redacted
+}
+
+func runCheck(ctx context.Context, timeout time.Duration, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{
+		Status:     StatusOK,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeReport(w http.ResponseWriter, status int, report interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	// Can't send error response after WriteHeader, the probe fails on the truncated body anyway
+	_ = json.NewEncoder(w).Encode(report)
+}
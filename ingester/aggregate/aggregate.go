@@ -0,0 +1,176 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// Aggregation holds summary statistics of a metric over a time window
+type Aggregation struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Sum   float64 `json:"sum"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// Compute calculates the summary statistics of the given points
+// Returns a zero Aggregation if points is empty
+func Compute(points []telemetrics.MetricPoint) Aggregation {
+	if len(points) == 0 {
+		return Aggregation{}
+	}
+
+	values := make([]float64, len(points))
+	sum := 0.0
+	for i, point := range points {
+		values[i] = point.Value
+		sum += point.Value
+	}
+
+	// Percentiles are computed on the sorted values
+	sort.Float64s(values)
+
+	return Aggregation{
+		Count: len(values),
+		Min:   values[0],
+		Max:   values[len(values)-1],
+		Mean:  sum / float64(len(values)),
+		Sum:   sum,
+		P50:   percentile(values, 50),
+		P95:   percentile(values, 95),
+		P99:   percentile(values, 99),
+	}
+}
+
+// ComputeFromRollups calculates the summary statistics from downsampled rollups
+// Min, max, mean, sum and count are exact, while percentiles are estimated from
+// the bucket averages weighted by their counts
+func ComputeFromRollups(rollups []telemetrics.RollupPoint) Aggregation {
+	merged := MergeRollups(0, rollups)
+	if merged.Count == 0 {
+		return Aggregation{}
+	}
+
+	// Each bucket average stands for Count samples, ranked by walking the cumulative counts
+	// instead of expanding them, so memory stays proportional to the number of buckets
+	buckets := make([]telemetrics.RollupPoint, 0, len(rollups))
+	for _, rollup := range rollups {
+		if rollup.Count > 0 {
+			buckets = append(buckets, rollup)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Avg < buckets[j].Avg
+	})
+
+	sum := merged.Avg * float64(merged.Count)
+	return Aggregation{
+		Count: merged.Count,
+		Min:   merged.Min,
+		Max:   merged.Max,
+		Mean:  merged.Avg,
+		Sum:   sum,
+		P50:   weightedPercentile(buckets, merged.Count, 50),
+		P95:   weightedPercentile(buckets, merged.Count, 95),
+		P99:   weightedPercentile(buckets, merged.Count, 99),
+	}
+}
+
+// Rollup summarizes raw points into a single bucket starting at timestamp
+func Rollup(timestamp int64, points []telemetrics.MetricPoint) telemetrics.RollupPoint {
+	rollup := telemetrics.RollupPoint{Timestamp: timestamp}
+	if len(points) == 0 {
+		return rollup
+	}
+
+	rollup.Min = points[0].Value
+	rollup.Max = points[0].Value
+	sum := 0.0
+	for _, point := range points {
+		rollup.Min = math.Min(rollup.Min, point.Value)
+		rollup.Max = math.Max(rollup.Max, point.Value)
+		sum += point.Value
+	}
+	rollup.Count = len(points)
+	rollup.Avg = sum / float64(rollup.Count)
+
+	return rollup
+}
+
+// MergeRollups combines finer buckets into a single coarser bucket starting at timestamp
+func MergeRollups(timestamp int64, rollups []telemetrics.RollupPoint) telemetrics.RollupPoint {
+	merged := telemetrics.RollupPoint{Timestamp: timestamp}
+	sum := 0.0
+	for _, rollup := range rollups {
+		if rollup.Count == 0 {
+			continue
+		}
+		if merged.Count == 0 {
+			merged.Min = rollup.Min
+			merged.Max = rollup.Max
+		}
+		merged.Min = math.Min(merged.Min, rollup.Min)
+		merged.Max = math.Max(merged.Max, rollup.Max)
+		merged.Count += rollup.Count
+		sum += rollup.Avg * float64(rollup.Count)
+	}
+
+	if merged.Count > 0 {
+		merged.Avg = sum / float64(merged.Count)
+	}
+
+	return merged
+}
+
+// percentile returns the p-th percentile of sorted values using linear interpolation
+// between the closest ranks
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+
+	fraction := rank - float64(lower)
+	return sorted[lower] + fraction*(sorted[upper]-sorted[lower])
+}
+
+// weightedPercentile returns the p-th percentile of count samples, given as buckets sorted by
+// their average, each standing for Count samples of that average
+// Same as percentile over the expanded samples, using linear interpolation between the closest ranks
+func weightedPercentile(sorted []telemetrics.RollupPoint, count int, p float64) float64 {
+	rank := p / 100 * float64(count-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	lowerValue := valueAtRank(sorted, lower)
+	if lower == upper {
+		return lowerValue
+	}
+
+	fraction := rank - float64(lower)
+	return lowerValue + fraction*(valueAtRank(sorted, upper)-lowerValue)
+}
+
+// valueAtRank returns the average of the bucket holding the sample of the given rank
+func valueAtRank(sorted []telemetrics.RollupPoint, rank int) float64 {
+	seen := 0
+	for _, bucket := range sorted {
+		seen += bucket.Count
+		if rank < seen {
+			return bucket.Avg
+		}
+	}
+	return sorted[len(sorted)-1].Avg
+}
@@ -0,0 +1,44 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestComputeFromRollups(t *testing.T) {
+	rollups := []telemetrics.RollupPoint{
+		{Timestamp: 120, Min: 5, Max: 40, Avg: 30, Count: 1},
+		{Timestamp: 0, Min: 1, Max: 20, Avg: 10, Count: 3},
+		{Timestamp: 60, Min: 2, Max: 30, Avg: 20, Count: 6},
+		{Timestamp: 180},
+	}
+
+	aggregation := ComputeFromRollups(rollups)
+
+	// The percentiles match the ones of the bucket averages repeated by their counts
+	points := []telemetrics.MetricPoint{}
+	for _, rollup := range rollups {
+		for i := 0; i < rollup.Count; i++ {
+			points = append(points, telemetrics.MetricPoint{Value: rollup.Avg})
+		}
+	}
+	expanded := Compute(points)
+
+	assert.Equal(t, 10, aggregation.Count)
+	assert.Equal(t, 1.0, aggregation.Min)
+	assert.Equal(t, 40.0, aggregation.Max)
+	assert.InDelta(t, 18.0, aggregation.Mean, 1e-9)
+	assert.InDelta(t, 180.0, aggregation.Sum, 1e-9)
+	assert.InDelta(t, expanded.P50, aggregation.P50, 1e-9)
+	assert.InDelta(t, expanded.P95, aggregation.P95, 1e-9)
+	assert.InDelta(t, expanded.P99, aggregation.P99, 1e-9)
+}
+
+func TestComputeFromRollups_SingleSample(t *testing.T) {
+	aggregation := ComputeFromRollups([]telemetrics.RollupPoint{{Min: 7, Max: 7, Avg: 7, Count: 1}})
+	assert.Equal(t, Aggregation{Count: 1, Min: 7, Max: 7, Mean: 7, Sum: 7, P50: 7, P95: 7, P99: 7}, aggregation)
+
+	assert.Equal(t, Aggregation{}, ComputeFromRollups(nil))
+}
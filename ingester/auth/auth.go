@@ -0,0 +1,247 @@
+// Package auth authenticates API clients with static API keys or HMAC-signed bearer tokens
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes granted to clients
+// The service has no write endpoint, telemetry is only pulled by the ETL, so there is no write scope
+const (
+	ScopeRead  = "read"  // Read telemetry
+	ScopeAdmin = "admin" // Operate the service, implies every other scope
+)
+
+const (
+	// APIKeyHeader carries a static API key
+	APIKeyHeader = "X-API-Key"
+	// tokenAlgorithm is the only accepted signing algorithm of bearer tokens
+	tokenAlgorithm = "HS256"
+	// minSecretLength is the minimum length of token secrets and API keys
+	minSecretLength = 16
+)
+
+var (
+	// ErrMissingCredentials is returned when a request carries no credentials
+	ErrMissingCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned when the credentials of a request are unknown, malformed or expired
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+var knownScopes = []string{ScopeRead, ScopeAdmin}
+
+// Principal is an authenticated client
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// HasScope reports whether the principal was granted the scope, admin granting every scope
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// KeysFile is the JSON file holding the credentials
+type KeysFile struct {
+	APIKeys      []APIKey      `json:"api_keys"`
+	TokenSecrets []TokenSecret `json:"token_secrets"`
+}
+
+// APIKey is a static key granted a set of scopes
+// The key is given either in clear or as the hex SHA-256 of the key
+type APIKey struct {
+	Name      string   `json:"name"`
+	Key       string   `json:"key,omitempty"`
+	KeySHA256 string   `json:"key_sha256,omitempty"`
+	Scopes    []string `json:"scopes"`
+}
+
+// TokenSecret is an HMAC secret signing bearer tokens
+// Tokens naming a key ID are only checked against the secret of that ID
+type TokenSecret struct {
+	KeyID  string `json:"kid"`
+	Secret string `json:"secret"`
+}
+
+// tokenHeader is the header of a bearer token
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// tokenClaims are the claims of a bearer token
+// The scopes are space-separated, and the expiry is required
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Scope     string `json:"scope"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+// Authenticator authenticates requests against the loaded credentials
+type Authenticator struct {
+	apiKeys map[[sha256.Size]byte]Principal // By SHA-256 of the key
+	secrets []TokenSecret
+	now     func() time.Time
+}
+
+// Load reads the credentials from the JSON keys file at path
+func Load(path string) (*Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth keys file: %w", err)
+	}
+
+	var file KeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse auth keys file: %w", err)
+	}
+
+	return New(file)
+}
+
+// New creates an Authenticator of the given credentials
+func New(file KeysFile) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys: make(map[[sha256.Size]byte]Principal, len(file.APIKeys)),
+		now:     time.Now,
+	}
+
+	for _, apiKey := range file.APIKeys {
+		hash, err := apiKey.hash()
+		if err != nil {
+			return nil, err
+		}
+		for _, scope := range apiKey.Scopes {
+			if !slices.Contains(knownScopes, scope) {
+				return nil, fmt.Errorf("unknown scope %q of api key %s", scope, apiKey.Name)
+			}
+		}
+		if _, exists := a.apiKeys[hash]; exists {
+			return nil, fmt.Errorf("duplicate api key %s", apiKey.Name)
+		}
+		a.apiKeys[hash] = Principal{Name: apiKey.Name, Scopes: apiKey.Scopes}
+	}
+
+	for _, secret := range file.TokenSecrets {
+		if len(secret.Secret) < minSecretLength {
+			return nil, fmt.Errorf("token secret %q is shorter than %d bytes", secret.KeyID, minSecretLength)
+		}
+		a.secrets = append(a.secrets, secret)
+	}
+
+	return a, nil
+}
+
+// hash returns the SHA-256 of the key
+func (k APIKey) hash() ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	switch {
+	case k.Key != "":
+		if len(k.Key) < minSecretLength {
+			return hash, fmt.Errorf("api key %s is shorter than %d bytes", k.Name, minSecretLength)
+		}
+		return sha256.Sum256([]byte(k.Key)), nil
+	case k.KeySHA256 != "":
+		decoded, err := hex.DecodeString(k.KeySHA256)
+		if err != nil || len(decoded) != sha256.Size {
+			return hash, fmt.Errorf("invalid key_sha256 of api key %s", k.Name)
+		}
+		copy(hash[:], decoded)
+		return hash, nil
+	default:
+		return hash, fmt.Errorf("api key %s has neither key nor key_sha256", k.Name)
+	}
+}
+
+// Authenticate returns the principal of the credentials of the request
+// An API key is read from the X-API-Key header, or from a bearer token that is not a signed token
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+
+	scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return Principal{}, ErrMissingCredentials
+	}
+
+	if strings.Count(credentials, ".") == 2 {
+		return a.authenticateToken(credentials)
+	}
+	return a.authenticateAPIKey(credentials)
+}
+
+// authenticateAPIKey looks the key up by its hash, so no key is compared byte by byte
+func (a *Authenticator) authenticateAPIKey(key string) (Principal, error) {
+	principal, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+// authenticateToken verifies a token of the form base64url(header).base64url(claims).base64url(signature),
+// signed with HMAC-SHA256 as a JWT
+func (a *Authenticator) authenticateToken(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != tokenAlgorithm {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, secret := range a.secrets {
+		if header.KeyID != "" && header.KeyID != secret.KeyID {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(secret.Secret))
+		mac.Write(signed)
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	now := a.now().Unix()
+	if claims.Subject == "" || claims.ExpiresAt == 0 || now >= claims.ExpiresAt || now < claims.NotBefore {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return Principal{Name: claims.Subject, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into v
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
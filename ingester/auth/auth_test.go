@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	readKey    = "read-key-0123456789"
+	noScopeKey = "no-scope-key-0123456789"
+	adminKey   = "admin-key-0123456789"
+	secretA    = "secret-a-0123456789"
+	secretB    = "secret-b-0123456789"
+)
+
+// testNow is the fixed time tokens are checked at
+var testNow = time.Unix(1700000000, 0)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	adminHash := sha256.Sum256([]byte(adminKey))
+
+	a, err := New(KeysFile{
+		APIKeys: []APIKey{
+			{Name: "reader", Key: readKey, Scopes: []string{ScopeRead}},
+			{Name: "unprivileged", Key: noScopeKey},
+			{Name: "operator", KeySHA256: hex.EncodeToString(adminHash[:]), Scopes: []string{ScopeAdmin}},
+		},
+		TokenSecrets: []TokenSecret{
+			{KeyID: "a", Secret: secretA},
+			{KeyID: "b", Secret: secretB},
+		},
+	})
+	require.NoError(t, err)
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+// signToken returns a token of the header and claims signed with HMAC-SHA256 using secret
+func signToken(t *testing.T, secret string, header map[string]interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validClaims returns the claims of a token valid at testNow
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "dashboard",
+		"scope": "read admin",
+		"exp":   testNow.Add(time.Hour).Unix(),
+	}
+}
+
+// authenticate authenticates a request carrying the given header
+func authenticate(a *Authenticator, name string, value string) (Principal, error) {
+	r, _ := http.NewRequest(http.MethodGet, "/telemetry/ListMetrics", nil)
+	if name != "" {
+		r.Header.Set(name, value)
+	}
+	return a.Authenticate(r)
+}
+
+func TestAuthenticate_MissingCredentials(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	_, err := authenticate(a, "", "")
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+
+	_, err = authenticate(a, "Authorization", "Basic dXNlcjpwYXNz")
+	assert.ErrorIs(t, err, ErrMissingCredentials)
+}
+
+func TestAuthenticate_APIKey(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	p, err := authenticate(a, APIKeyHeader, readKey)
+	require.NoError(t, err)
+	assert.Equal(t, Principal{Name: "reader", Scopes: []string{ScopeRead}}, p)
+
+	// API keys are also accepted as bearer credentials
+	p, err = authenticate(a, "Authorization", "Bearer "+noScopeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "unprivileged", p.Name)
+
+	_, err = authenticate(a, APIKeyHeader, "unknown-key-0123456789")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAuthenticate_APIKeyByHash(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	// The key is only configured by its hash
+	p, err := authenticate(a, APIKeyHeader, adminKey)
+	require.NoError(t, err)
+	assert.Equal(t, "operator", p.Name)
+
+	// The hash itself is not a key
+	adminHash := sha256.Sum256([]byte(adminKey))
+	_, err = authenticate(a, APIKeyHeader, hex.EncodeToString(adminHash[:]))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAuthenticate_Token(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	// Tokens without a key ID are checked against every secret
+	for _, header := range []map[string]interface{}{
+		{"alg": "HS256", "kid": "a"},
+		{"alg": "HS256"},
+	} {
+		token := signToken(t, secretA, header, validClaims())
+		p, err := authenticate(a, "Authorization", "Bearer "+token)
+		require.NoError(t, err)
+		assert.Equal(t, Principal{Name: "dashboard", Scopes: []string{ScopeRead, ScopeAdmin}}, p)
+	}
+}
+
+func TestAuthenticate_InvalidTokens(t *testing.T) {
+	a := newTestAuthenticator(t)
+	hs256 := map[string]interface{}{"alg": "HS256", "kid": "a"}
+
+	expired := validClaims()
+	expired["exp"] = testNow.Unix()
+
+	notYetValid := validClaims()
+	notYetValid["nbf"] = testNow.Add(time.Minute).Unix()
+
+	withoutExpiry := validClaims()
+	delete(withoutExpiry, "exp")
+
+	withoutSubject := validClaims()
+	delete(withoutSubject, "sub")
+
+	valid := signToken(t, secretA, hs256, validClaims())
+	parts := strings.Split(valid, ".")
+	forged := signToken(t, secretA, hs256, map[string]interface{}{
+		"sub": "dashboard", "scope": "admin", "exp": testNow.Add(time.Hour).Unix(),
+	})
+	tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"bad signature", signToken(t, "some-other-secret-0123", hs256, validClaims())},
+		{"tampered claims", tampered},
+		{"truncated signature", parts[0] + "." + parts[1] + "." + parts[2][:10]},
+		{"alg none", signToken(t, secretA, map[string]interface{}{"alg": "none", "kid": "a"}, validClaims())},
+		{"alg HS512", signToken(t, secretA, map[string]interface{}{"alg": "HS512", "kid": "a"}, validClaims())},
+		{"kid of another secret", signToken(t, secretA, map[string]interface{}{"alg": "HS256", "kid": "b"}, validClaims())},
+		{"unknown kid", signToken(t, secretA, map[string]interface{}{"alg": "HS256", "kid": "c"}, validClaims())},
+		{"expired", signToken(t, secretA, hs256, expired)},
+		{"before nbf", signToken(t, secretA, hs256, notYetValid)},
+		{"without exp", signToken(t, secretA, hs256, withoutExpiry)},
+		{"without sub", signToken(t, secretA, hs256, withoutSubject)},
+		{"malformed header", "bm90LWpzb24." + parts[1] + "." + parts[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authenticate(a, "Authorization", "Bearer "+tt.token)
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+}
+
+func TestPrincipal_HasScope(t *testing.T) {
+	reader := Principal{Name: "reader", Scopes: []string{ScopeRead}}
+	assert.True(t, reader.HasScope(ScopeRead))
+	assert.False(t, reader.HasScope(ScopeAdmin))
+
+	admin := Principal{Name: "operator", Scopes: []string{ScopeAdmin}}
+	for _, scope := range knownScopes {
+		assert.True(t, admin.HasScope(scope), scope)
+	}
+
+	assert.False(t, Principal{Name: "nobody"}.HasScope(ScopeRead))
+}
+
+func TestNew_InvalidKeysFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file KeysFile
+	}{
+		{"short api key", KeysFile{APIKeys: []APIKey{{Name: "k", Key: "short", Scopes: []string{ScopeRead}}}}},
+		{"invalid key hash", KeysFile{APIKeys: []APIKey{{Name: "k", KeySHA256: "abc", Scopes: []string{ScopeRead}}}}},
+		{"no key", KeysFile{APIKeys: []APIKey{{Name: "k", Scopes: []string{ScopeRead}}}}},
+		{"unknown scope", KeysFile{APIKeys: []APIKey{{Name: "k", Key: readKey, Scopes: []string{"write"}}}}},
+		{"ingest scope", KeysFile{APIKeys: []APIKey{{Name: "k", Key: readKey, Scopes: []string{"ingest"}}}}},
+		{"duplicate key", KeysFile{APIKeys: []APIKey{
+			{Name: "k1", Key: readKey, Scopes: []string{ScopeRead}},
+			{Name: "k2", Key: readKey, Scopes: []string{ScopeAdmin}},
+		}}},
+		{"short secret", KeysFile{TokenSecrets: []TokenSecret{{KeyID: "a", Secret: "short"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.file)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -1,12 +1,23 @@
 package bootstrap
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/ingester/auth"
 	"github.com/yaron8/telemetry-infra/ingester/config"
 	"github.com/yaron8/telemetry-infra/ingester/dao"
 	"github.com/yaron8/telemetry-infra/ingester/etl"
+	"github.com/yaron8/telemetry-infra/ingester/notify"
+	"github.com/yaron8/telemetry-infra/ingester/rollup"
 	"github.com/yaron8/telemetry-infra/ingester/service"
 	"github.com/yaron8/telemetry-infra/logi"
 	"github.com/yaron8/telemetry-infra/telemetrics"
@@ -16,7 +27,10 @@ type Bootstrap struct {
 	config         *config.Config
 	allowedMetrics map[string]bool
 	apiServer      *service.APIServer
-	daoMetrics     *dao.DAOMetrics
+	daoMetrics     dao.MetricStore
+	broker         notify.Broker
+	etl            *etl.ETL
+	storageCloser  io.Closer // Closes the Redis client, nil with the memory backend
 }
 
 func NewBootstrap() (*Bootstrap, error) {
@@ -29,6 +43,13 @@ func NewBootstrap() (*Bootstrap, error) {
 	// Load configuration
 	cfg := config.NewConfig()
 
+	// The finest rollup is computed from the raw data, which must still be there once its bucket completes
+	if len(cfg.Retention.Rollups) > 0 && cfg.Retention.Raw < cfg.Retention.Rollups[0].Resolution+cfg.ETL.Interval {
+		logi.GetLogger().Warn("Raw retention is shorter than the finest rollup resolution plus the ETL interval, rollups will miss data",
+			"raw_retention", cfg.Retention.Raw,
+			"rollup_resolution", cfg.Retention.Rollups[0].Resolution)
+	}
+
 	allowedMetrics := map[string]bool{}
 	for _, metric := range telemetrics.GetCSVHeader() {
 		if metric != "switch_id" {
@@ -36,14 +57,23 @@ func NewBootstrap() (*Bootstrap, error) {
 		}
 	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
-		Password: "", // no password set
-		DB:       0,  // use default DB
-		Protocol: 2,
-	})
+	daoMetrics, broker, storageCloser, err := newStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticator, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
 
-	daoMetrics := dao.NewDAOMetrics(redisClient, cfg.Redis.TTL)
+	etl := etl.NewETL(
+		daoMetrics,
+		broker,
+		cfg.ETL.Interval,
+		cfg.ETL.GeneratorURL,
+		cfg.ETL.BatchSize,
+	)
 
 	return &Bootstrap{
 		config:         cfg,
@@ -51,24 +81,262 @@ func NewBootstrap() (*Bootstrap, error) {
 		apiServer: service.NewAPIServer(
 			cfg,
 			daoMetrics,
+			broker,
+			etl,
+			authenticator,
 		),
-		daoMetrics: daoMetrics,
+		daoMetrics:    daoMetrics,
+		broker:        broker,
+		etl:           etl,
+		storageCloser: storageCloser,
 	}, nil
 }
 
-func (b *Bootstrap) Start() error {
+// newAuthenticator loads the credentials of the API clients
+// Returns nil if authentication is disabled
+func newAuthenticator(cfg config.AuthConfig) (*auth.Authenticator, error) {
+	if cfg.KeysFile == "" {
+		logi.GetLogger().Warn("AUTH_KEYS_FILE is not set, the API is served without authentication")
+		return nil, nil
+	}
+	return auth.Load(cfg.KeysFile)
+}
+
+// newStorage creates the storage backend selected in the configuration, the broker
+// notifying snapshot commits through the same backend, and the closer of its connections
+func newStorage(cfg *config.Config) (dao.MetricStore, notify.Broker, io.Closer, error) {
+	switch cfg.Storage.Backend {
+	case config.StorageBackendRedis:
+		redisClient, err := newRedisClient(cfg.Redis)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		redisClient.AddHook(dao.RedisMetricsHook{})
+		broker := notify.NewRedisBroker(redisClient)
+		var store dao.MetricStore
+		switch cfg.Redis.Layout {
+		case config.RedisLayoutSnapshot:
+			store = dao.NewDAOMetrics(redisClient, cfg.Retention.Raw)
+		case config.RedisLayoutSortedSet:
+			store = dao.NewSortedSetDAOMetrics(redisClient, cfg.Retention.Raw)
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown redis layout: %s", cfg.Redis.Layout)
+		}
+		// The pointer is re-read every ETL interval in case a commit notification was missed
+		if cfg.Cache.Enabled {
+			store = dao.NewCachedMetrics(store, cfg.Retention.Raw, cfg.ETL.Interval)
+		}
+		return store, broker, redisClient, nil
+	case config.StorageBackendMemory:
+		return dao.NewMemoryMetrics(cfg.Retention.Raw), notify.NewLocalBroker(), nil, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown storage backend: %s", cfg.Storage.Backend)
+	}
+}
+
+// newRedisClient creates a Redis client for the configured topology
+func newRedisClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	password, err := loadRedisPassword(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newRedisTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case config.RedisModeStandalone:
+		return redis.NewClient(&redis.Options{
+			Addr:      fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+			Username:  cfg.Username,
+			Password:  password,
+			DB:        cfg.DB,
+			Protocol:  2,
+			TLSConfig: tlsConfig,
+		}), nil
+	case config.RedisModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode requires REDIS_MASTER_NAME and REDIS_ADDRS")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         password,
+			DB:               cfg.DB,
+			Protocol:         2,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case config.RedisModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("cluster mode requires REDIS_ADDRS")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     cfg.Addrs,
+			Username:  cfg.Username,
+			Password:  password,
+			Protocol:  2,
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode: %s", cfg.Mode)
+	}
+}
+
+// loadRedisPassword returns the configured password, reading it from the password file if needed
+func loadRedisPassword(cfg config.RedisConfig) (string, error) {
+	if cfg.Password != "" || cfg.PasswordFile == "" {
+		return cfg.Password, nil
+	}
+
+	data, err := os.ReadFile(cfg.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read redis password file: %w", err)
+	}
+
+	// Secrets mounted from files usually end with a newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// newRedisTLSConfig builds the TLS configuration for Redis connections
+// Returns nil if TLS is disabled
+func newRedisTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificates found in redis CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Start runs the background jobs and serves the API until the context is cancelled, then
+// shuts down gracefully within the shutdown timeout: the in-flight requests are drained,
+// the background jobs stopped, the Redis client closed and the logs flushed
+func (b *Bootstrap) Start(ctx context.Context) error {
 	logger := logi.GetLogger()
 	logger.Info("Bootstrap is starting")
 
-	etl := etl.NewETL(
+	// The background jobs outlive the context, they are stopped once the requests are drained
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	var jobs sync.WaitGroup
+	runJob := func(job func(ctx context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(jobsCtx)
+		}()
+	}
+
+	// Relay the commits of every instance to the local subscribers
+	if redisBroker, ok := b.broker.(*notify.RedisBroker); ok {
+		runJob(redisBroker.Listen)
+	}
+
+	// Load every committed snapshot into the cache ahead of the first read
+	if cache, ok := b.daoMetrics.(*dao.CachedMetrics); ok {
+		commits, release := b.broker.Subscribe()
+		runJob(func(ctx context.Context) {
+			defer release()
+			cache.Follow(ctx, commits)
+		})
+	}
+
+	runJob(b.etl.Run)
+
+	rollup := rollup.NewRollup(
 		b.daoMetrics,
+		b.config.Retention.Raw,
+		b.config.Retention.Rollups,
+		b.config.Retention.RollupInterval,
 		b.config.ETL.Interval,
-		b.config.ETL.GeneratorURL,
 	)
+	runJob(rollup.Run)
 
+	serveErr := make(chan error, 1)
 	go func() {
-		etl.Run()
+		serveErr <- b.apiServer.Start()
 	}()
 
-	return b.apiServer.Start()
+	select {
+	case err := <-serveErr:
+		// The server failed to start, nothing to drain
+		stopJobs()
+		jobs.Wait()
+		return errors.Join(err, b.closeStorage(), logi.Close())
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutdown signal received, shutting down", "timeout", b.config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), b.config.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := b.apiServer.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		errs = append(errs, fmt.Errorf("background jobs still running after %s", b.config.ShutdownTimeout))
+	}
+
+	errs = append(errs, <-serveErr, b.closeStorage())
+
+	err := errors.Join(errs...)
+	if err != nil {
+		logger.Error("Shutdown failed", "error", err)
+	} else {
+		logger.Info("Shutdown complete")
+	}
+
+	// Flush the logs last, nothing is logged afterwards
+	return errors.Join(err, logi.Close())
+}
+
+// closeStorage closes the Redis client
+func (b *Bootstrap) closeStorage() error {
+	if b.storageCloser == nil {
+		return nil
+	}
+	if err := b.storageCloser.Close(); err != nil {
+		return fmt.Errorf("failed to close redis client: %w", err)
+	}
+	return nil
 }
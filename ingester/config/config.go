@@ -1,26 +1,111 @@
 package config
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	StorageBackendRedis  = "redis"
+	StorageBackendMemory = "memory"
+
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+
+	RedisLayoutSnapshot  = "snapshot"
+	RedisLayoutSortedSet = "zset"
+
+	FreshnessPolicyServe  = "serve"
+	FreshnessPolicyReject = "reject"
+)
+
 type Config struct {
-	Port  int // Port
-	Redis RedisConfig
-	ETL   ETLConfig
+	Port      int // Port
+	Storage   StorageConfig
+	Redis     RedisConfig
+	Retention RetentionConfig
+	ETL       ETLConfig
+	Freshness FreshnessConfig
+	Auth      AuthConfig
+	RateLimit RateLimitConfig
+	Cache     CacheConfig
+
+	ShutdownTimeout time.Duration // How long in-flight requests and background jobs get to finish on shutdown
+}
+
+type StorageConfig struct {
+	Backend string // One of StorageBackendRedis or StorageBackendMemory
 }
 
 type RedisConfig struct {
-	Host string
-	Port int
-	TTL  time.Duration
+	Mode         string   // One of RedisModeStandalone, RedisModeSentinel or RedisModeCluster
+	Host         string   // Standalone host
+	Port         int      // Standalone port
+	Addrs        []string // Sentinel addresses in sentinel mode, seed node addresses in cluster mode
+	MasterName   string   // Sentinel master name
+	DB           int      // Database number, not supported in cluster mode
+	Username     string   // ACL username, empty for the default user
+	Password     string   // Password, takes precedence over PasswordFile
+	PasswordFile string   // File holding the password, e.g. a mounted secret
+	TLS          RedisTLSConfig
+	Layout       string // One of RedisLayoutSnapshot or RedisLayoutSortedSet
+
+	// Sentinels authenticate separately from the data nodes, they are reached without
+	// credentials if these are empty
+	SentinelUsername string
+	SentinelPassword string
+}
+
+type RedisTLSConfig struct {
+	Enabled    bool
+	CAFile     string // PEM CA bundle used to verify the server, system roots if empty
+	CertFile   string // PEM client certificate for mutual TLS
+	KeyFile    string // PEM client private key for mutual TLS
+	ServerName string // Expected server name, defaults to the host being dialed
+}
+
+type RetentionConfig struct {
+	Raw            time.Duration // Raw snapshot data expires after this
+	Rollups        []RollupTier  // Ordered from the finest to the coarsest resolution
+	RollupInterval time.Duration // How often the rollup job looks for completed buckets
+}
+
+// RollupTier is a downsampled resolution of the raw data and how long it is kept
+type RollupTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
 }
 
 type ETLConfig struct {
 	Interval     time.Duration
 	GeneratorURL string
+	BatchSize    int // Number of records written per pipelined round-trip
+}
+
+// FreshnessConfig is how the API treats the latest snapshot once the ETL stops committing
+type FreshnessConfig struct {
+	MaxAge time.Duration // The latest snapshot is stale once older than this
+	Policy string        // One of FreshnessPolicyServe or FreshnessPolicyReject
+}
+
+type AuthConfig struct {
+	KeysFile string // JSON file of the API keys and token secrets, authentication is disabled if empty
+}
+
+type RateLimitConfig struct {
+	Rate          float64       // Requests per second of each client, rate limiting is disabled if 0
+	Burst         int           // Requests a client can send at once above the rate
+	MaxConcurrent int           // Expensive requests served at once, unlimited if 0
+	MaxWait       time.Duration // How long an expensive request waits for a slot before being shed
+}
+
+type CacheConfig struct {
+	Enabled bool // Cache the latest snapshot in memory, only used with the redis backend
 }
 
 func NewConfig() *Config {
@@ -38,22 +123,234 @@ func NewConfig() *Config {
 		}
 	}
 
+	// Read Redis topology from environment variables, default to a standalone server
+	redisMode := os.Getenv("REDIS_MODE")
+	if redisMode == "" {
+		redisMode = RedisModeStandalone
+	}
+
+	var redisAddrs []string
+	if redisAddrsStr := os.Getenv("REDIS_ADDRS"); redisAddrsStr != "" {
+		for _, addr := range strings.Split(redisAddrsStr, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				redisAddrs = append(redisAddrs, addr)
+			}
+		}
+	}
+
+	redisDB := 0
+	if redisDBStr := os.Getenv("REDIS_DB"); redisDBStr != "" {
+		if db, err := strconv.Atoi(redisDBStr); err == nil {
+			redisDB = db
+		}
+	}
+
+	// Read Redis TLS flag from environment variable, default to plain TCP
+	redisTLSEnabled := false
+	if redisTLSEnabledStr := os.Getenv("REDIS_TLS_ENABLED"); redisTLSEnabledStr != "" {
+		if enabled, err := strconv.ParseBool(redisTLSEnabledStr); err == nil {
+			redisTLSEnabled = enabled
+		}
+	}
+
+	// Read Redis key layout from environment variable, default to snapshot keys
+	redisLayout := os.Getenv("REDIS_LAYOUT")
+	if redisLayout == "" {
+		redisLayout = RedisLayoutSnapshot
+	}
+
+	// Read raw data retention from environment variable, default to 2 minutes
+	// It must cover the finest rollup resolution plus one ETL interval
+	rawRetention := 2 * time.Minute
+	if rawRetentionStr := os.Getenv("RETENTION_RAW"); rawRetentionStr != "" {
+		if retention, err := time.ParseDuration(rawRetentionStr); err == nil && retention > 0 {
+			rawRetention = retention
+		}
+	}
+
+	// Read rollup tiers from environment variable as comma-separated resolution:retention pairs
+	// Default to 1m rollups kept for a day, 5m rollups kept for a week and 1h rollups kept for 30 days
+	rollupTiers := []RollupTier{
+		{Resolution: time.Minute, Retention: 24 * time.Hour},
+		{Resolution: 5 * time.Minute, Retention: 7 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 30 * 24 * time.Hour},
+	}
+	if rollupTiersStr := os.Getenv("ROLLUP_TIERS"); rollupTiersStr != "" {
+		if tiers, err := parseRollupTiers(rollupTiersStr); err == nil {
+			rollupTiers = tiers
+		}
+	}
+
+	// Read storage backend from environment variable, default to redis
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = StorageBackendRedis
+	}
+
 	// Read generator URL from environment variable, default to localhost
 	generatorURL := os.Getenv("GENERATOR_URL")
 	if generatorURL == "" {
 		generatorURL = "http://localhost:9001"
 	}
 
+	// Read ETL batch size from environment variable, default to 500
+	etlBatchSize := 500
+	if etlBatchSizeStr := os.Getenv("ETL_BATCH_SIZE"); etlBatchSizeStr != "" {
+		if batchSize, err := strconv.Atoi(etlBatchSizeStr); err == nil && batchSize > 0 {
+			etlBatchSize = batchSize
+		}
+	}
+
+	etlInterval := 10 * time.Second
+
+	// Read the freshness threshold from environment variable, default to 3 ETL intervals
+	freshnessMaxAge := 3 * etlInterval
+	if freshnessMaxAgeStr := os.Getenv("FRESHNESS_MAX_AGE"); freshnessMaxAgeStr != "" {
+		if maxAge, err := time.ParseDuration(freshnessMaxAgeStr); err == nil && maxAge > 0 {
+			freshnessMaxAge = maxAge
+		}
+	}
+
+	// Read the freshness policy from environment variable, default to serving stale data with a warning
+	freshnessPolicy := os.Getenv("FRESHNESS_POLICY")
+	if freshnessPolicy != FreshnessPolicyReject {
+		freshnessPolicy = FreshnessPolicyServe
+	}
+
+	// Read the per-client rate limit from environment variables, default to no rate limiting
+	rateLimitRate := 0.0
+	if rateLimitRateStr := os.Getenv("RATE_LIMIT_RPS"); rateLimitRateStr != "" {
+		if rate, err := strconv.ParseFloat(rateLimitRateStr, 64); err == nil && rate >= 0 {
+			rateLimitRate = rate
+		}
+	}
+
+	// Default to bursts of 2 seconds of requests
+	rateLimitBurst := max(int(math.Ceil(2*rateLimitRate)), 1)
+	if rateLimitBurstStr := os.Getenv("RATE_LIMIT_BURST"); rateLimitBurstStr != "" {
+		if burst, err := strconv.Atoi(rateLimitBurstStr); err == nil && burst > 0 {
+			rateLimitBurst = burst
+		}
+	}
+
+	// Read the concurrency limit of expensive endpoints from environment variable, default to 64
+	maxConcurrent := 64
+	if maxConcurrentStr := os.Getenv("MAX_CONCURRENT_EXPENSIVE"); maxConcurrentStr != "" {
+		if limit, err := strconv.Atoi(maxConcurrentStr); err == nil && limit >= 0 {
+			maxConcurrent = limit
+		}
+	}
+
+	// Read how long expensive requests queue for a slot from environment variable, default to 500ms
+	maxWait := 500 * time.Millisecond
+	if maxWaitStr := os.Getenv("LOAD_SHED_MAX_WAIT"); maxWaitStr != "" {
+		if wait, err := time.ParseDuration(maxWaitStr); err == nil && wait >= 0 {
+			maxWait = wait
+		}
+	}
+
+	// Read the snapshot cache flag from environment variable, default to enabled
+	cacheEnabled := true
+	if cacheEnabledStr := os.Getenv("SNAPSHOT_CACHE_ENABLED"); cacheEnabledStr != "" {
+		if enabled, err := strconv.ParseBool(cacheEnabledStr); err == nil {
+			cacheEnabled = enabled
+		}
+	}
+
+	// Read the shutdown timeout from environment variable, default to 20 seconds
+	shutdownTimeout := 20 * time.Second
+	if shutdownTimeoutStr := os.Getenv("SHUTDOWN_TIMEOUT"); shutdownTimeoutStr != "" {
+		if timeout, err := time.ParseDuration(shutdownTimeoutStr); err == nil && timeout > 0 {
+			shutdownTimeout = timeout
+		}
+	}
+
 	return &Config{
-		Port: 8080,
+		Port:            8080,
+		ShutdownTimeout: shutdownTimeout,
+		Storage: StorageConfig{
+			Backend: storageBackend,
+		},
 		Redis: RedisConfig{
-			Host: redisHost,
-			Port: redisPort,
-			TTL:  30 * time.Second,
+			Mode:         redisMode,
+			Host:         redisHost,
+			Port:         redisPort,
+			Addrs:        redisAddrs,
+			MasterName:   os.Getenv("REDIS_MASTER_NAME"),
+			DB:           redisDB,
+			Username:     os.Getenv("REDIS_USERNAME"),
+			Password:     os.Getenv("REDIS_PASSWORD"),
+			PasswordFile: os.Getenv("REDIS_PASSWORD_FILE"),
+			TLS: RedisTLSConfig{
+				Enabled:    redisTLSEnabled,
+				CAFile:     os.Getenv("REDIS_TLS_CA_FILE"),
+				CertFile:   os.Getenv("REDIS_TLS_CERT_FILE"),
+				KeyFile:    os.Getenv("REDIS_TLS_KEY_FILE"),
+				ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+			},
+			Layout:           redisLayout,
+			SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+			SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		},
+		Retention: RetentionConfig{
+			Raw:            rawRetention,
+			Rollups:        rollupTiers,
+			RollupInterval: 15 * time.Second,
 		},
 		ETL: ETLConfig{
-			Interval:     10 * time.Second,
+			Interval:     etlInterval,
 			GeneratorURL: generatorURL,
+			BatchSize:    etlBatchSize,
 		},
+		Freshness: FreshnessConfig{
+			MaxAge: freshnessMaxAge,
+			Policy: freshnessPolicy,
+		},
+		Auth: AuthConfig{
+			KeysFile: os.Getenv("AUTH_KEYS_FILE"),
+		},
+		RateLimit: RateLimitConfig{
+			Rate:          rateLimitRate,
+			Burst:         rateLimitBurst,
+			MaxConcurrent: maxConcurrent,
+			MaxWait:       maxWait,
+		},
+		Cache: CacheConfig{
+			Enabled: cacheEnabled,
+		},
+	}
+}
+
+// parseRollupTiers parses comma-separated resolution:retention pairs, e.g. "1m:24h,5m:168h"
+// Each resolution must be a multiple of the previous one, since coarser rollups are
+// computed from finer ones
+func parseRollupTiers(value string) ([]RollupTier, error) {
+	var tiers []RollupTier
+	for _, pair := range strings.Split(value, ",") {
+		rawResolution, rawRetention, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid rollup tier %q: expected resolution:retention", pair)
+		}
+
+		resolution, err := time.ParseDuration(rawResolution)
+		if err != nil || resolution < time.Second {
+			return nil, fmt.Errorf("invalid rollup resolution %q", rawResolution)
+		}
+
+		retention, err := time.ParseDuration(rawRetention)
+		if err != nil || retention < resolution {
+			return nil, fmt.Errorf("invalid rollup retention %q", rawRetention)
+		}
+
+		if len(tiers) > 0 {
+			previous := tiers[len(tiers)-1].Resolution
+			if resolution <= previous || resolution%previous != 0 {
+				return nil, fmt.Errorf("rollup resolution %s is not a multiple of %s", resolution, previous)
+			}
+		}
+
+		tiers = append(tiers, RollupTier{Resolution: resolution, Retention: retention})
 	}
+
+	return tiers, nil
 }
@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/instrument"
+	"github.com/yaron8/telemetry-infra/logi"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+var snapshotCacheRequests = instrument.NewCounterVec("ingester_snapshot_cache_requests_total",
+	"Snapshot reads by whether they were served from the in-process cache.", "result")
+
+var _ MetricStore = (*CachedMetrics)(nil)
+
+// CachedMetrics keeps the decoded latest snapshot of a MetricStore in memory, so reads of the
+// latest snapshot never reach the store
+// The latest snapshot pointer follows the commits seen by Follow and SetLastUpdateTime, and is
+// re-read from the store once per revalidate interval in case a notification was missed
+// Reads of older snapshots and of history are passed through to the store
+type CachedMetrics struct {
+	MetricStore
+	ttl        time.Duration // Snapshots expire in the store after this
+	revalidate time.Duration
+
+	mu          sync.RWMutex
+	latest      int64
+	validatedAt time.Time
+	snapshot    *cachedSnapshot // Nil until the latest snapshot is loaded
+
+	loadMu sync.Mutex // Serializes loads, so a commit triggers a single full read of the store
+}
+
+// cachedSnapshot is the decoded snapshot of a timestamp
+type cachedSnapshot struct {
+	timestamp int64
+	records   map[string]telemetrics.MetricRecord
+	switchIDs []string // Sorted
+}
+
+// NewCachedMetrics creates a cache of the latest snapshot of store
+// Snapshots expire after ttl like in the store, and the pointer is re-read every revalidate interval
+func NewCachedMetrics(store MetricStore, ttl time.Duration, revalidate time.Duration) *CachedMetrics {
+	return &CachedMetrics{
+		MetricStore: store,
+		ttl:         ttl,
+		revalidate:  revalidate,
+	}
+}
+
+// Follow moves the latest snapshot to the timestamps of the commits, and loads each new snapshot
+// ahead of the first read, until the context is cancelled or commits is closed
+func (c *CachedMetrics) Follow(ctx context.Context, commits <-chan int64) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case timestamp, ok := <-commits:
+			if !ok {
+				return
+			}
+			c.advance(timestamp)
+			if _, err := c.load(ctx, timestamp); err != nil {
+				logi.GetLogger().Error("Error loading committed snapshot into the cache", "snapshot", timestamp, "error", err)
+			}
+		}
+	}
+}
+
+// SetLastUpdateTime moves the pointer in the store, then in the cache
+func (c *CachedMetrics) SetLastUpdateTime(ctx context.Context, timestamp int64) error {
+	if err := c.MetricStore.SetLastUpdateTime(ctx, timestamp); err != nil {
+		return err
+	}
+	c.advance(timestamp)
+	return nil
+}
+
+// GetLastUpdateTime returns the cached pointer, re-reading it from the store once it is
+// older than the revalidate interval
+func (c *CachedMetrics) GetLastUpdateTime(ctx context.Context) (int64, error) {
+	c.mu.RLock()
+	latest, validatedAt := c.latest, c.validatedAt
+	c.mu.RUnlock()
+
+	if latest > 0 && time.Since(validatedAt) < c.revalidate {
+		return latest, nil
+	}
+
+	timestamp, err := c.MetricStore.GetLastUpdateTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.advance(timestamp)
+	return timestamp, nil
+}
+
+// GetAll retrieves the records of the switches selected by the filter, ordered by switch ID
+// if read from the cache
+func (c *CachedMetrics) GetAll(ctx context.Context,
+	timestamp int64,
+	filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	snapshot, err := c.get(ctx, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return c.MetricStore.GetAll(ctx, timestamp, filter)
+	}
+
+	result := make([]map[string]telemetrics.MetricRecord, 0, len(snapshot.switchIDs))
+	for _, switchID := range snapshot.switchIDs {
+		if filter.Match(switchID) {
+			result = append(result, map[string]telemetrics.MetricRecord{
+				switchID: snapshot.records[switchID],
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// GetMetric retrieves a single metric value of a switch
+func (c *CachedMetrics) GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error) {
+	snapshot, err := c.get(ctx, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return c.MetricStore.GetMetric(ctx, timestamp, switchID, metric)
+	}
+
+	record, exists := snapshot.records[switchID]
+	if !exists {
+		return nil, ErrSwitchNotFound
+	}
+
+	value, exists := record.GetMetricValue(metric)
+	if !exists {
+		return nil, ErrMetricNotFound
+	}
+
+	return value, nil
+}
+
+// GetMetrics retrieves the values of the given metrics for the given switches
+// Switches without a record in the snapshot are omitted
+func (c *CachedMetrics) GetMetrics(ctx context.Context,
+	timestamp int64,
+	switchIDs []string,
+	metrics []string) (map[string]map[string]float64, error) {
+	for _, metric := range metrics {
+		if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+			return nil, ErrMetricNotFound
+		}
+	}
+
+	snapshot, err := c.get(ctx, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return c.MetricStore.GetMetrics(ctx, timestamp, switchIDs, metrics)
+	}
+
+	records := make(map[string]telemetrics.MetricRecord, len(switchIDs))
+	for _, switchID := range switchIDs {
+		if record, exists := snapshot.records[switchID]; exists {
+			records[switchID] = record
+		}
+	}
+
+	return selectMetrics(records, metrics), nil
+}
+
+// GetTopK retrieves the k switches with the highest (desc) or lowest values of a metric,
+// ordered by rank
+func (c *CachedMetrics) GetTopK(ctx context.Context,
+	timestamp int64,
+	metric string,
+	k int,
+	desc bool) ([]telemetrics.RankedSwitch, error) {
+	if k <= 0 {
+		return nil, ErrInvalidTopK
+	}
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	snapshot, err := c.get(ctx, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return c.MetricStore.GetTopK(ctx, timestamp, metric, k, desc)
+	}
+
+	ranking := make([]telemetrics.RankedSwitch, 0, len(snapshot.records))
+	for switchID, record := range snapshot.records {
+		value, _ := record.GetMetricValue(metric)
+		ranking = append(ranking, telemetrics.RankedSwitch{
+			SwitchID: switchID,
+			Value:    value,
+		})
+	}
+
+	return topK(ranking, k, desc), nil
+}
+
+// advance moves the latest snapshot pointer forward, dropping the cached snapshot it replaces
+func (c *CachedMetrics) advance(timestamp int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if timestamp < c.latest {
+		return
+	}
+	c.validatedAt = time.Now()
+	if timestamp == c.latest {
+		return
+	}
+
+	c.latest = timestamp
+	if c.snapshot != nil && c.snapshot.timestamp != timestamp {
+		c.snapshot = nil
+	}
+}
+
+// get returns the cached snapshot of the timestamp, loading it if it is the latest one
+// Returns nil if the timestamp is not the latest snapshot or has expired, the read is then
+// passed through to the store
+func (c *CachedMetrics) get(ctx context.Context, timestamp int64) (*cachedSnapshot, error) {
+	c.mu.RLock()
+	latest, snapshot := c.latest, c.snapshot
+	c.mu.RUnlock()
+
+	if timestamp != latest || time.Since(time.Unix(timestamp, 0)) > c.ttl {
+		snapshotCacheRequests.Inc("bypass")
+		return nil, nil
+	}
+	if snapshot != nil && snapshot.timestamp == timestamp {
+		snapshotCacheRequests.Inc("hit")
+		return snapshot, nil
+	}
+
+	snapshotCacheRequests.Inc("miss")
+	return c.load(ctx, timestamp)
+}
+
+// load reads the snapshot of the timestamp from the store and caches it, unless a newer
+// snapshot was committed meanwhile
+func (c *CachedMetrics) load(ctx context.Context, timestamp int64) (*cachedSnapshot, error) {
+	c.loadMu.Lock()
+	defer c.loadMu.Unlock()
+
+	// Another request may have loaded it while this one was waiting
+	c.mu.RLock()
+	snapshot := c.snapshot
+	c.mu.RUnlock()
+	if snapshot != nil && snapshot.timestamp == timestamp {
+		return snapshot, nil
+	}
+
+	entries, err := c.MetricStore.GetAll(ctx, timestamp, SwitchFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot = &cachedSnapshot{
+		timestamp: timestamp,
+		records:   make(map[string]telemetrics.MetricRecord, len(entries)),
+		switchIDs: make([]string, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		for switchID, record := range entry {
+			snapshot.records[switchID] = record
+			snapshot.switchIDs = append(snapshot.switchIDs, switchID)
+		}
+	}
+	sort.Strings(snapshot.switchIDs)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timestamp == c.latest {
+		c.snapshot = snapshot
+	}
+
+	return snapshot, nil
+}
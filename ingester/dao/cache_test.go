@@ -0,0 +1,274 @@
+package dao
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/ingester/notify"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// countingStore counts the reads of the store behind the cache
+type countingStore struct {
+	*MemoryMetrics
+
+	mu        sync.Mutex
+	getAll    int
+	getMetric int
+	// beforeGetAll is called by GetAll before reading the store, if set
+	beforeGetAll func()
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{MemoryMetrics: NewMemoryMetrics(time.Minute)}
+}
+
+func (s *countingStore) GetAll(ctx context.Context, timestamp int64, filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	s.mu.Lock()
+	s.getAll++
+	hook := s.beforeGetAll
+	s.mu.Unlock()
+
+	if hook != nil {
+		hook()
+	}
+	return s.MemoryMetrics.GetAll(ctx, timestamp, filter)
+}
+
+func (s *countingStore) GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error) {
+	s.mu.Lock()
+	s.getMetric++
+	s.mu.Unlock()
+	return s.MemoryMetrics.GetMetric(ctx, timestamp, switchID, metric)
+}
+
+// reads returns the number of GetAll and GetMetric calls
+func (s *countingStore) reads() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getAll, s.getMetric
+}
+
+// commitThroughCache commits the records through the cache, like the ETL does
+func commitThroughCache(t *testing.T, cache *CachedMetrics, timestamp int64, bandwidth float64) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, cache.AddMetrics(ctx, timestamp, map[string]telemetrics.MetricRecord{
+		"sw1": {BandwidthMbps: bandwidth},
+		"sw2": {BandwidthMbps: bandwidth * 2},
+	}))
+	require.NoError(t, cache.SetLastUpdateTime(ctx, timestamp))
+}
+
+func TestCachedMetrics_MissThenHit(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+	now := time.Now().Unix()
+	commitThroughCache(t, cache, now, 100)
+
+	// The first read loads the whole snapshot
+	value, err := cache.GetMetric(ctx, now, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, value)
+	getAll, getMetric := store.reads()
+	assert.Equal(t, 1, getAll)
+	assert.Zero(t, getMetric)
+
+	// Later reads never reach the store
+	value, err = cache.GetMetric(ctx, now, "sw2", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, value)
+
+	_, err = cache.GetMetric(ctx, now, "sw9", "bandwidth_mbps")
+	assert.ErrorIs(t, err, ErrSwitchNotFound)
+	_, err = cache.GetMetric(ctx, now, "sw1", "cpu")
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	all, err := cache.GetAll(ctx, now, SwitchFilter{Prefix: "sw"})
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]telemetrics.MetricRecord{
+		{"sw1": {BandwidthMbps: 100}},
+		{"sw2": {BandwidthMbps: 200}},
+	}, all)
+
+	values, err := cache.GetMetrics(ctx, now, []string{"sw2", "sw9"}, []string{"bandwidth_mbps"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]float64{"sw2": {"bandwidth_mbps": 200}}, values)
+
+	ranking, err := cache.GetTopK(ctx, now, "bandwidth_mbps", 1, true)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RankedSwitch{{SwitchID: "sw2", Value: 200}}, ranking)
+
+	getAll, getMetric = store.reads()
+	assert.Equal(t, 1, getAll)
+	assert.Zero(t, getMetric)
+}
+
+func TestCachedMetrics_BypassOlderSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+	now := time.Now().Unix()
+	commitThroughCache(t, cache, now-10, 100)
+	commitThroughCache(t, cache, now, 300)
+
+	// Point-in-time reads of an older snapshot go to the store
+	value, err := cache.GetMetric(ctx, now-10, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, value)
+
+	getAll, getMetric := store.reads()
+	assert.Zero(t, getAll)
+	assert.Equal(t, 1, getMetric)
+}
+
+func TestCachedMetrics_BypassExpiredSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+
+	// The latest snapshot is older than the TTL, its records are gone from the store
+	expired := time.Now().Add(-2 * time.Minute).Unix()
+	commitThroughCache(t, cache, expired, 100)
+
+	_, err := cache.GetMetric(ctx, expired, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	_, err = cache.GetAll(ctx, expired, SwitchFilter{})
+	require.NoError(t, err)
+
+	getAll, getMetric := store.reads()
+	assert.Equal(t, 1, getAll)
+	assert.Equal(t, 1, getMetric)
+	assert.Nil(t, cache.snapshot)
+}
+
+func TestCachedMetrics_NewerCommitDropsSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+	now := time.Now().Unix()
+	commitThroughCache(t, cache, now-10, 100)
+
+	_, err := cache.GetMetric(ctx, now-10, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	require.NotNil(t, cache.snapshot)
+
+	commitThroughCache(t, cache, now, 300)
+	assert.Nil(t, cache.snapshot, "the snapshot of the previous commit must be dropped")
+
+	latest, err := cache.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now, latest)
+
+	value, err := cache.GetMetric(ctx, now, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 300.0, value)
+
+	getAll, _ := store.reads()
+	assert.Equal(t, 2, getAll)
+
+	// An older commit, e.g. from a slow instance, doesn't move the pointer back
+	require.NoError(t, cache.SetLastUpdateTime(ctx, now-10))
+	latest, err = cache.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now, latest)
+	assert.NotNil(t, cache.snapshot)
+}
+
+func TestCachedMetrics_LoadRacingNewerCommit(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+	now := time.Now().Unix()
+	commitThroughCache(t, cache, now-10, 100)
+	require.NoError(t, store.AddMetrics(ctx, now, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 300}}))
+
+	// A newer snapshot is committed while the previous one is being loaded
+	store.beforeGetAll = func() {
+		store.beforeGetAll = nil
+		require.NoError(t, cache.SetLastUpdateTime(ctx, now))
+	}
+
+	value, err := cache.GetMetric(ctx, now-10, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, value, "the read is still served from the snapshot it asked for")
+	assert.Nil(t, cache.snapshot, "the outdated snapshot must not be cached")
+
+	value, err = cache.GetMetric(ctx, now, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 300.0, value)
+}
+
+func TestCachedMetrics_RevalidatesPointer(t *testing.T) {
+	ctx := context.Background()
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, 50*time.Millisecond)
+	now := time.Now().Unix()
+	commitThroughCache(t, cache, now-10, 100)
+
+	// Another instance committed, but the notification was missed
+	require.NoError(t, store.SetLastUpdateTime(ctx, now))
+
+	latest, err := cache.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now-10, latest, "the pointer is trusted within the revalidate interval")
+
+	time.Sleep(60 * time.Millisecond)
+	latest, err = cache.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now, latest)
+}
+
+func TestCachedMetrics_FollowsBrokerCommits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newCountingStore()
+	cache := NewCachedMetrics(store, time.Minute, time.Minute)
+	broker := notify.NewLocalBroker()
+	commits, release := broker.Subscribe()
+	defer release()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Follow(ctx, commits)
+	}()
+
+	// Another instance commits the snapshot and publishes it
+	now := time.Now().Unix()
+	require.NoError(t, store.AddMetrics(ctx, now, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 100}}))
+	require.NoError(t, store.SetLastUpdateTime(ctx, now))
+	require.NoError(t, broker.Publish(ctx, now))
+
+	// The snapshot is loaded ahead of the first read
+	require.Eventually(t, func() bool {
+		cache.mu.RLock()
+		defer cache.mu.RUnlock()
+		return cache.snapshot != nil && cache.snapshot.timestamp == now
+	}, time.Second, 5*time.Millisecond)
+
+	latest, err := cache.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now, latest)
+
+	value, err := cache.GetMetric(ctx, now, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, value)
+
+	getAll, getMetric := store.reads()
+	assert.Equal(t, 1, getAll)
+	assert.Zero(t, getMetric)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Follow did not return once the context was cancelled")
+	}
+}
@@ -0,0 +1,43 @@
+package dao
+
+import (
+	"path"
+	"strings"
+)
+
+// SwitchFilter selects the switches a snapshot read returns
+// The zero value selects every switch
+type SwitchFilter struct {
+	Prefix  string // Switch IDs must start with this prefix
+	Pattern string // Switch IDs must match this glob pattern, e.g. "sw1?" or "sw[1-3]*"
+}
+
+// Validate returns an error if the glob pattern is malformed
+func (f SwitchFilter) Validate() error {
+	if f.Pattern == "" {
+		return nil
+	}
+	_, err := path.Match(f.Pattern, "")
+	return err
+}
+
+// Match reports whether the switch is selected by the filter
+func (f SwitchFilter) Match(switchID string) bool {
+	if !strings.HasPrefix(switchID, f.Prefix) {
+		return false
+	}
+	if f.Pattern == "" {
+		return true
+	}
+	matched, err := path.Match(f.Pattern, switchID)
+	return err == nil && matched
+}
+
+// scanPattern returns a Redis glob pattern covering every switch selected by the filter,
+// so only the matching keys are scanned
+func (f SwitchFilter) scanPattern() string {
+	if f.Pattern != "" {
+		return f.Pattern
+	}
+	return escapeScanPattern(f.Prefix) + "*"
+}
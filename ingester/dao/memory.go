@@ -0,0 +1,459 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+var _ MetricStore = (*MemoryMetrics)(nil)
+
+// MemoryMetrics is an in-process MetricStore, used when no Redis is available
+// Records expire after the TTL just like the Redis keys do
+type MemoryMetrics struct {
+	mu             sync.RWMutex
+	snapshots      map[int64]map[string]memoryEntry
+	lastUpdateTime int64
+	committed      []int64 // Committed snapshot timestamps, ascending
+	ttl            time.Duration
+	// rollups are indexed by resolution, then switchID, then metric
+	rollups     map[time.Duration]map[string]map[string][]telemetrics.RollupPoint
+	lastRollups map[time.Duration]int64 // Start of the last bucket rolled up per resolution
+}
+
+type memoryEntry struct {
+	record    telemetrics.MetricRecord
+	expiresAt time.Time
+}
+
+// NewMemoryMetrics creates a new in-memory metrics store
+func NewMemoryMetrics(ttl time.Duration) *MemoryMetrics {
+	return &MemoryMetrics{
+		snapshots:   make(map[int64]map[string]memoryEntry),
+		ttl:         ttl,
+		rollups:     make(map[time.Duration]map[string]map[string][]telemetrics.RollupPoint),
+		lastRollups: make(map[time.Duration]int64),
+	}
+}
+
+// AddMetric saves a MetricRecord under the snapshot of the given timestamp
+func (m *MemoryMetrics) AddMetric(ctx context.Context,
+	timestamp int64,
+	switchID string,
+	record telemetrics.MetricRecord) error {
+	return m.AddMetrics(ctx, timestamp, map[string]telemetrics.MetricRecord{
+		switchID: record,
+	})
+}
+
+// AddMetrics saves a batch of MetricRecords, keyed by switchID, under the snapshot of the given timestamp
+func (m *MemoryMetrics) AddMetrics(ctx context.Context,
+	timestamp int64,
+	records map[string]telemetrics.MetricRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	snapshot, exists := m.snapshots[timestamp]
+	if !exists {
+		// A new snapshot is starting - drop the ones that fully expired
+		m.evictExpired(now)
+		snapshot = make(map[string]memoryEntry)
+		m.snapshots[timestamp] = snapshot
+	}
+
+	for switchID, record := range records {
+		snapshot[switchID] = memoryEntry{
+			record:    record,
+			expiresAt: now.Add(m.ttl),
+		}
+	}
+
+	return nil
+}
+
+// SetLastUpdateTime points readers to the snapshot of the given timestamp
+// The pointer is never moved back to an older snapshot
+func (m *MemoryMetrics) SetLastUpdateTime(ctx context.Context, timestamp int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Record the snapshot in the index, dropping the ones older than the TTL
+	cutoff := timestamp - int64(m.ttl.Seconds())
+	committed := make([]int64, 0, len(m.committed)+1)
+	for _, existing := range m.committed {
+		if existing >= cutoff && existing != timestamp {
+			committed = append(committed, existing)
+		}
+	}
+	committed = append(committed, timestamp)
+	sort.Slice(committed, func(i, j int) bool {
+		return committed[i] < committed[j]
+	})
+	m.committed = committed
+
+	if timestamp > m.lastUpdateTime {
+		m.lastUpdateTime = timestamp
+	}
+	return nil
+}
+
+// Ping always succeeds, the store lives in the process
+func (m *MemoryMetrics) Ping(ctx context.Context) error {
+	return nil
+}
+
+// GetLastUpdateTime returns the timestamp of the latest committed snapshot
+func (m *MemoryMetrics) GetLastUpdateTime(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.getLastTimeUpdated()
+}
+
+// GetSnapshotTimeAt returns the timestamp of the latest committed snapshot at or before at
+func (m *MemoryMetrics) GetSnapshotTimeAt(ctx context.Context, at int64) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Index of the first snapshot after at
+	i := sort.Search(len(m.committed), func(i int) bool {
+		return m.committed[i] > at
+	})
+	if i == 0 {
+		return 0, ErrSnapshotNotFound
+	}
+
+	return m.committed[i-1], nil
+}
+
+// GetAll retrieves the metrics of the switches selected by the filter in the snapshot of the given
+// timestamp as a slice of single-entry maps
+func (m *MemoryMetrics) GetAll(ctx context.Context,
+	timestamp int64,
+	filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	snapshot := m.snapshots[timestamp]
+	result := make([]map[string]telemetrics.MetricRecord, 0, len(snapshot))
+	for switchID, entry := range snapshot {
+		if now.After(entry.expiresAt) || !filter.Match(switchID) {
+			continue
+		}
+		result = append(result, map[string]telemetrics.MetricRecord{
+			switchID: entry.record,
+		})
+	}
+
+	return result, nil
+}
+
+// GetMetric retrieves a specific metric value of a switch from the snapshot of the given timestamp
+func (m *MemoryMetrics) GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.snapshots[timestamp][switchID]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return nil, ErrSwitchNotFound
+	}
+
+	value, exists := entry.record.GetMetricValue(metric)
+	if !exists {
+		return nil, ErrMetricNotFound
+	}
+
+	return value, nil
+}
+
+// GetMetrics retrieves the values of the given metrics for the given switches from the snapshot
+// of the given timestamp
+// Switches without a record in the snapshot are omitted
+func (m *MemoryMetrics) GetMetrics(ctx context.Context,
+	timestamp int64,
+	switchIDs []string,
+	metrics []string) (map[string]map[string]float64, error) {
+	for _, metric := range metrics {
+		if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+			return nil, ErrMetricNotFound
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	records := make(map[string]telemetrics.MetricRecord, len(switchIDs))
+	for _, switchID := range switchIDs {
+		entry, exists := m.snapshots[timestamp][switchID]
+		if !exists || now.After(entry.expiresAt) {
+			continue
+		}
+		records[switchID] = entry.record
+	}
+
+	return selectMetrics(records, metrics), nil
+}
+
+// GetTopK retrieves the k switches with the highest (desc) or lowest values of a metric
+// in the snapshot of the given timestamp, ordered by rank
+func (m *MemoryMetrics) GetTopK(ctx context.Context,
+	timestamp int64,
+	metric string,
+	k int,
+	desc bool) ([]telemetrics.RankedSwitch, error) {
+	if k <= 0 {
+		return nil, ErrInvalidTopK
+	}
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	ranking := make([]telemetrics.RankedSwitch, 0, len(m.snapshots[timestamp]))
+	for switchID, entry := range m.snapshots[timestamp] {
+		if now.After(entry.expiresAt) {
+			continue
+		}
+		value, _ := entry.record.GetMetricValue(metric)
+		ranking = append(ranking, telemetrics.RankedSwitch{
+			SwitchID: switchID,
+			Value:    value,
+		})
+	}
+
+	return topK(ranking, k, desc), nil
+}
+
+// GetMetricHistory retrieves the values of a metric for a given switch from every stored
+// snapshot whose timestamp is within [from, to], ordered by timestamp ascending
+func (m *MemoryMetrics) GetMetricHistory(ctx context.Context,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) ([]telemetrics.MetricPoint, error) {
+	series, err := m.getMetricSeries(metric, from, to, func(id string) bool {
+		return id == switchID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points, exists := series[switchID]
+	if !exists {
+		return []telemetrics.MetricPoint{}, nil
+	}
+
+	return points, nil
+}
+
+// GetAllMetricHistory retrieves the values of a metric for every switch from every stored
+// snapshot whose timestamp is within [from, to]
+func (m *MemoryMetrics) GetAllMetricHistory(ctx context.Context,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.MetricPoint, error) {
+	return m.getMetricSeries(metric, from, to, func(string) bool {
+		return true
+	})
+}
+
+// getMetricSeries groups the values of the metric by switchID for the switches accepted
+// by match, keeping only snapshots within [from, to]
+func (m *MemoryMetrics) getMetricSeries(metric string,
+	from int64,
+	to int64,
+	match func(switchID string) bool) (map[string][]telemetrics.MetricPoint, error) {
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Only committed snapshots are visible to readers
+	lastTimeUpdated, err := m.getLastTimeUpdated()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving last update time: %w", err)
+	}
+	to = min(to, lastTimeUpdated)
+
+	now := time.Now()
+	series := make(map[string][]telemetrics.MetricPoint)
+	for timestamp, snapshot := range m.snapshots {
+		if timestamp < from || timestamp > to {
+			continue
+		}
+		for switchID, entry := range snapshot {
+			if !match(switchID) || now.After(entry.expiresAt) {
+				continue
+			}
+			value, _ := entry.record.GetMetricValue(metric)
+			series[switchID] = append(series[switchID], telemetrics.MetricPoint{
+				Timestamp: timestamp,
+				Value:     value,
+			})
+		}
+	}
+
+	for _, points := range series {
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp < points[j].Timestamp
+		})
+	}
+
+	return series, nil
+}
+
+// AddRollups saves the rollups of a metric, keyed by switchID, for the given resolution
+// Buckets older than the retention are trimmed
+func (m *MemoryMetrics) AddRollups(ctx context.Context,
+	resolution time.Duration,
+	retention time.Duration,
+	metric string,
+	rollups map[string]telemetrics.RollupPoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switches, exists := m.rollups[resolution]
+	if !exists {
+		switches = make(map[string]map[string][]telemetrics.RollupPoint)
+		m.rollups[resolution] = switches
+	}
+
+	for switchID, rollup := range rollups {
+		if switches[switchID] == nil {
+			switches[switchID] = make(map[string][]telemetrics.RollupPoint)
+		}
+
+		// Keep buckets within the retention, replacing the bucket if it was already rolled up
+		cutoff := rollup.Timestamp - int64(retention.Seconds())
+		kept := make([]telemetrics.RollupPoint, 0, len(switches[switchID][metric])+1)
+		for _, existing := range switches[switchID][metric] {
+			if existing.Timestamp >= cutoff && existing.Timestamp != rollup.Timestamp {
+				kept = append(kept, existing)
+			}
+		}
+		kept = append(kept, rollup)
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].Timestamp < kept[j].Timestamp
+		})
+		switches[switchID][metric] = kept
+	}
+
+	return nil
+}
+
+// GetRollupHistory retrieves the rollups of a metric for a given switch at the given resolution
+// whose bucket timestamp is within [from, to], ordered by timestamp ascending
+func (m *MemoryMetrics) GetRollupHistory(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) ([]telemetrics.RollupPoint, error) {
+	series, err := m.getRollupSeries(resolution, metric, from, to, func(id string) bool {
+		return id == switchID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rollups, exists := series[switchID]
+	if !exists {
+		return []telemetrics.RollupPoint{}, nil
+	}
+
+	return rollups, nil
+}
+
+// GetAllRollupHistory retrieves the rollups of a metric for every switch at the given resolution
+// whose bucket timestamp is within [from, to]
+func (m *MemoryMetrics) GetAllRollupHistory(ctx context.Context,
+	resolution time.Duration,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.RollupPoint, error) {
+	return m.getRollupSeries(resolution, metric, from, to, func(string) bool {
+		return true
+	})
+}
+
+// SetLastRollup records the start of the last bucket rolled up at the given resolution
+// The bucket is never moved back
+func (m *MemoryMetrics) SetLastRollup(ctx context.Context, resolution time.Duration, bucket int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastRollups[resolution] = max(m.lastRollups[resolution], bucket)
+	return nil
+}
+
+// GetLastRollup returns the start of the last bucket rolled up at the given resolution, 0 if none
+func (m *MemoryMetrics) GetLastRollup(ctx context.Context, resolution time.Duration) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastRollups[resolution], nil
+}
+
+func (m *MemoryMetrics) getRollupSeries(resolution time.Duration,
+	metric string,
+	from int64,
+	to int64,
+	match func(switchID string) bool) (map[string][]telemetrics.RollupPoint, error) {
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	series := make(map[string][]telemetrics.RollupPoint)
+	for switchID, metrics := range m.rollups[resolution] {
+		if !match(switchID) {
+			continue
+		}
+		for _, rollup := range metrics[metric] {
+			if rollup.Timestamp >= from && rollup.Timestamp <= to {
+				series[switchID] = append(series[switchID], rollup)
+			}
+		}
+	}
+
+	return series, nil
+}
+
+// getLastTimeUpdated must be called with the lock held
+func (m *MemoryMetrics) getLastTimeUpdated() (int64, error) {
+	if m.lastUpdateTime == 0 {
+		return 0, ErrSnapshotNotFound
+	}
+	return m.lastUpdateTime, nil
+}
+
+// evictExpired removes snapshots whose records have all expired
+// Must be called with the write lock held
+func (m *MemoryMetrics) evictExpired(now time.Time) {
+	for timestamp, snapshot := range m.snapshots {
+		expired := true
+		for _, entry := range snapshot {
+			if !now.After(entry.expiresAt) {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			delete(m.snapshots, timestamp)
+		}
+	}
+}
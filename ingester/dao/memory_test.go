@@ -0,0 +1,204 @@
+package dao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// testRecords returns the records of a snapshot with three switches
+func testRecords() map[string]telemetrics.MetricRecord {
+	return map[string]telemetrics.MetricRecord{
+		"sw1": {BandwidthMbps: 100, LatencyMs: 1.5, PacketErrors: 0},
+		"sw2": {BandwidthMbps: 300, LatencyMs: 0.5, PacketErrors: 2},
+		"sw3": {BandwidthMbps: 200, LatencyMs: 2.5, PacketErrors: 1},
+	}
+}
+
+// commitSnapshot saves the records under the snapshot of the given timestamp and points readers to it
+func commitSnapshot(t *testing.T, store MetricStore, timestamp int64, records map[string]telemetrics.MetricRecord) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, store.AddMetrics(ctx, timestamp, records))
+	require.NoError(t, store.SetLastUpdateTime(ctx, timestamp))
+}
+
+func TestMemoryMetrics_NoSnapshot(t *testing.T) {
+	store := NewMemoryMetrics(time.Minute)
+
+	_, err := store.GetLastUpdateTime(context.Background())
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+
+	_, err = store.GetSnapshotTimeAt(context.Background(), time.Now().Unix())
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+}
+
+func TestMemoryMetrics_ReadSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(time.Minute)
+	commitSnapshot(t, store, 1000, testRecords())
+
+	latest, err := store.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), latest)
+
+	all, err := store.GetAll(ctx, 1000, SwitchFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	filtered, err := store.GetAll(ctx, 1000, SwitchFilter{Pattern: "sw2"})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, 300.0, filtered[0]["sw2"].BandwidthMbps)
+
+	value, err := store.GetMetric(ctx, 1000, "sw3", "latency_ms")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, value)
+
+	_, err = store.GetMetric(ctx, 1000, "sw9", "latency_ms")
+	assert.ErrorIs(t, err, ErrSwitchNotFound)
+
+	_, err = store.GetMetric(ctx, 1000, "sw1", "cpu")
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	values, err := store.GetMetrics(ctx, 1000, []string{"sw1", "sw9"}, []string{"bandwidth_mbps", "packet_errors"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]float64{
+		"sw1": {"bandwidth_mbps": 100, "packet_errors": 0},
+	}, values)
+
+	_, err = store.GetMetrics(ctx, 1000, []string{"sw1"}, []string{"cpu"})
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestMemoryMetrics_GetTopK(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(time.Minute)
+	commitSnapshot(t, store, 1000, testRecords())
+
+	highest, err := store.GetTopK(ctx, 1000, "bandwidth_mbps", 2, true)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RankedSwitch{
+		{SwitchID: "sw2", Value: 300},
+		{SwitchID: "sw3", Value: 200},
+	}, highest)
+
+	lowest, err := store.GetTopK(ctx, 1000, "latency_ms", 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RankedSwitch{{SwitchID: "sw2", Value: 0.5}}, lowest)
+
+	_, err = store.GetTopK(ctx, 1000, "cpu", 1, true)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestMemoryMetrics_LastUpdateTimeOnlyMovesForward(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(time.Minute)
+	commitSnapshot(t, store, 1010, testRecords())
+	commitSnapshot(t, store, 1000, testRecords())
+
+	latest, err := store.GetLastUpdateTime(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1010), latest)
+
+	// The older snapshot is still committed for point-in-time reads
+	at, err := store.GetSnapshotTimeAt(ctx, 1005)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), at)
+
+	_, err = store.GetSnapshotTimeAt(ctx, 999)
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+}
+
+func TestMemoryMetrics_RecordsExpire(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(20 * time.Millisecond)
+	commitSnapshot(t, store, 1000, testRecords())
+
+	_, err := store.GetMetric(ctx, 1000, "sw1", "bandwidth_mbps")
+	require.NoError(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, err = store.GetMetric(ctx, 1000, "sw1", "bandwidth_mbps")
+	assert.ErrorIs(t, err, ErrSwitchNotFound)
+
+	all, err := store.GetAll(ctx, 1000, SwitchFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, all)
+}
+
+func TestMemoryMetrics_MetricHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(time.Minute)
+	commitSnapshot(t, store, 1020, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 30}})
+	commitSnapshot(t, store, 1000, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 10}})
+	commitSnapshot(t, store, 1010, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 20}, "sw2": {BandwidthMbps: 5}})
+
+	// Snapshots written but not committed yet are not visible
+	require.NoError(t, store.AddMetrics(ctx, 1030, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 40}}))
+
+	points, err := store.GetMetricHistory(ctx, "sw1", "bandwidth_mbps", 1000, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.MetricPoint{
+		{Timestamp: 1000, Value: 10},
+		{Timestamp: 1010, Value: 20},
+		{Timestamp: 1020, Value: 30},
+	}, points)
+
+	points, err = store.GetMetricHistory(ctx, "sw9", "bandwidth_mbps", 1000, 2000)
+	require.NoError(t, err)
+	assert.Empty(t, points)
+
+	series, err := store.GetAllMetricHistory(ctx, "bandwidth_mbps", 1005, 1015)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]telemetrics.MetricPoint{
+		"sw1": {{Timestamp: 1010, Value: 20}},
+		"sw2": {{Timestamp: 1010, Value: 5}},
+	}, series)
+
+	_, err = store.GetAllMetricHistory(ctx, "cpu", 1000, 2000)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestMemoryMetrics_Rollups(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryMetrics(time.Minute)
+	retention := 3 * time.Minute
+
+	for _, bucket := range []int64{0, 60, 120} {
+		require.NoError(t, store.AddRollups(ctx, time.Minute, retention, "latency_ms", map[string]telemetrics.RollupPoint{
+			"sw1": {Timestamp: bucket, Min: 1, Max: 3, Avg: 2, Count: 6},
+		}))
+	}
+
+	// Rolling a bucket up again replaces it
+	require.NoError(t, store.AddRollups(ctx, time.Minute, retention, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": {Timestamp: 120, Min: 4, Max: 4, Avg: 4, Count: 1},
+	}))
+
+	rollups, err := store.GetRollupHistory(ctx, time.Minute, "sw1", "latency_ms", 60, 120)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RollupPoint{
+		{Timestamp: 60, Min: 1, Max: 3, Avg: 2, Count: 6},
+		{Timestamp: 120, Min: 4, Max: 4, Avg: 4, Count: 1},
+	}, rollups)
+
+	// Buckets older than the retention are trimmed by the next write
+	require.NoError(t, store.AddRollups(ctx, time.Minute, retention, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": {Timestamp: 240, Min: 1, Max: 1, Avg: 1, Count: 1},
+	}))
+	series, err := store.GetAllRollupHistory(ctx, time.Minute, "latency_ms", 0, 300)
+	require.NoError(t, err)
+	require.Len(t, series["sw1"], 3)
+	assert.Equal(t, int64(60), series["sw1"][0].Timestamp)
+
+	// Other resolutions are stored separately
+	rollups, err = store.GetRollupHistory(ctx, 5*time.Minute, "sw1", "latency_ms", 0, 300)
+	require.NoError(t, err)
+	assert.Empty(t, rollups)
+}
@@ -2,7 +2,7 @@ package dao
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -13,154 +13,264 @@ import (
 
 const (
 	LastUpdateTimeKey = "last_update_time"
+	// SnapshotsKey is a sorted set indexing the committed snapshot timestamps
+	SnapshotsKey = "snapshots"
 )
 
-// DAOMetrics handles telemetry metrics storage and retrieval
+// setLastUpdateTimeScript moves the last update time pointer only if the new timestamp is newer,
+// so a slow or concurrent ETL can never move readers back to an older snapshot
+var setLastUpdateTimeScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+var _ MetricStore = (*DAOMetrics)(nil)
+
+// redisLayout defines how metric records are laid out in Redis keys
+type redisLayout interface {
+	// queueMetric queues the commands storing the record of a switch for the snapshot
+	// of the given timestamp on the pipeline
+	queueMetric(ctx context.Context, pipe redis.Pipeliner, timestamp int64, switchID string, record telemetrics.MetricRecord) error
+	// getSnapshot retrieves the records of the switches selected by the filter in the snapshot
+	// of the given timestamp
+	getSnapshot(ctx context.Context, timestamp int64, filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error)
+	// getRecord retrieves the record of a switch in the snapshot of the given timestamp
+	getRecord(ctx context.Context, timestamp int64, switchID string) (telemetrics.MetricRecord, error)
+	// getRecords retrieves the records of the given switches in the snapshot of the given timestamp
+	// in a single pipeline
+	// Switches without a record are omitted from the result
+	getRecords(ctx context.Context, timestamp int64, switchIDs []string) (map[string]telemetrics.MetricRecord, error)
+	// getMetricSeries retrieves the values of a metric within [from, to] grouped by switchID
+	// An empty switchID selects all switches
+	getMetricSeries(ctx context.Context, switchID string, metric string, from int64, to int64) (map[string][]telemetrics.MetricPoint, error)
+}
+
+// DAOMetrics handles telemetry metrics storage and retrieval in Redis
 type DAOMetrics struct {
-	redisClient *redis.Client
-	ttl         time.Duration
+	redisClient redis.UniversalClient
+	layout      redisLayout
+	retention   time.Duration
 }
 
 // NewDAOMetrics creates a new Metrics instance with the provided Redis client
-func NewDAOMetrics(redisClient *redis.Client, ttl time.Duration) *DAOMetrics {
+// Each snapshot row is stored as a JSON string under {timestamp}/{switch_id} with the given TTL
+func NewDAOMetrics(redisClient redis.UniversalClient, ttl time.Duration) *DAOMetrics {
 	return &DAOMetrics{
 		redisClient: redisClient,
-		ttl:         ttl,
+		layout: &snapshotLayout{
+			redisClient: redisClient,
+			ttl:         ttl,
+		},
+		retention: ttl,
 	}
 }
 
-// AddMetric saves a MetricRecord to Redis with the given key
+// NewSortedSetDAOMetrics creates a new Metrics instance with the provided Redis client
+// Each switch/metric pair is stored as a sorted set scored by timestamp, trimmed to the retention
+func NewSortedSetDAOMetrics(redisClient redis.UniversalClient, retention time.Duration) *DAOMetrics {
+	return &DAOMetrics{
+		redisClient: redisClient,
+		layout: &sortedSetLayout{
+			redisClient: redisClient,
+			retention:   retention,
+		},
+		retention: retention,
+	}
+}
+
+// Ping checks the Redis server can be reached
+func (dao *DAOMetrics) Ping(ctx context.Context) error {
+	return dao.redisClient.Ping(ctx).Err()
+}
+
+// AddMetric saves a MetricRecord to Redis for the given snapshot timestamp
 func (dao *DAOMetrics) AddMetric(ctx context.Context,
 	timestamp int64,
 	switchID string,
 	record telemetrics.MetricRecord) error {
-	data, err := json.Marshal(record)
-	if err != nil {
-		return err
+	return dao.AddMetrics(ctx, timestamp, map[string]telemetrics.MetricRecord{
+		switchID: record,
+	})
+}
+
+// AddMetrics saves a batch of MetricRecords, keyed by switchID, to Redis for the given
+// snapshot timestamp using a single pipelined round-trip
+func (dao *DAOMetrics) AddMetrics(ctx context.Context,
+	timestamp int64,
+	records map[string]telemetrics.MetricRecord) error {
+	pipe := dao.redisClient.Pipeline()
+	for switchID, record := range records {
+		if err := dao.layout.queueMetric(ctx, pipe, timestamp, switchID, record); err != nil {
+			return fmt.Errorf("error preparing metric of switch %s: %w", switchID, err)
+		}
 	}
+	dao.queueRankings(ctx, pipe, timestamp, records)
 
-	// Build the Redis key
-	key := dao.buildMetricKey(timestamp, switchID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("error executing pipeline: %w", err)
+	}
 
-	// Store the JSON data in Redis with TTL
-	return dao.redisClient.Set(ctx, key, data, dao.ttl).Err()
+	return nil
 }
 
+// SetLastUpdateTime atomically points readers to the snapshot of the given timestamp
+// The snapshot is recorded in the snapshot index first, so point-in-time reads can find it
+// The pointer is never moved back to an older snapshot
 func (dao *DAOMetrics) SetLastUpdateTime(ctx context.Context, timestamp int64) error {
-	return dao.redisClient.Set(ctx, LastUpdateTimeKey, timestamp, 0).Err()
-}
+	cutoff := "(" + strconv.FormatInt(timestamp-int64(dao.retention.Seconds()), 10)
 
-// GetAll retrieves all metrics from Redis and returns them as a slice of maps
-// Each map contains a single key-value pair where the key is the Redis key
-// and the value is the MetricRecord
-func (dao *DAOMetrics) GetAll(ctx context.Context) ([]map[string]telemetrics.MetricRecord, error) {
-	// Get last time updated to build the search pattern
-	lastTimeUpdated, err := dao.getLastTimeUpdated(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error retrieving last update time: %w", err)
+	pipe := dao.redisClient.Pipeline()
+	pipe.ZAdd(ctx, SnapshotsKey, redis.Z{Score: float64(timestamp), Member: timestamp})
+	pipe.ZRemRangeByScore(ctx, SnapshotsKey, "-inf", cutoff)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("error indexing snapshot: %w", err)
 	}
 
-	// Build the pattern: <last_time_updated>/*
-	pattern := fmt.Sprintf("%d/*", lastTimeUpdated)
+	return setLastUpdateTimeScript.Run(ctx, dao.redisClient, []string{LastUpdateTimeKey}, timestamp).Err()
+}
 
-	// Use SCAN instead of KEYS to avoid blocking Redis
-	var keys []string
-	var cursor uint64
-	for {
-		var scanKeys []string
-		var err error
-		scanKeys, cursor, err = dao.redisClient.Scan(ctx, cursor, pattern, 100).Result()
-		if err != nil {
-			return nil, err
-		}
-		keys = append(keys, scanKeys...)
-		if cursor == 0 {
-			break
-		}
+// GetLastUpdateTime returns the timestamp of the latest committed snapshot
+func (dao *DAOMetrics) GetLastUpdateTime(ctx context.Context) (int64, error) {
+	return dao.getLastTimeUpdated(ctx)
+}
+
+// GetSnapshotTimeAt returns the timestamp of the latest committed snapshot at or before at
+// The snapshot index is used, so no key is scanned
+func (dao *DAOMetrics) GetSnapshotTimeAt(ctx context.Context, at int64) (int64, error) {
+	members, err := dao.redisClient.ZRevRangeByScore(ctx, SnapshotsKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(at, 10),
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving snapshot index: %w", err)
 	}
 
-	if len(keys) == 0 {
-		return []map[string]telemetrics.MetricRecord{}, nil
+	if len(members) == 0 {
+		return 0, ErrSnapshotNotFound
 	}
 
-	// Use pipeline to fetch all values in batch
-	pipe := dao.redisClient.Pipeline()
-	cmds := make([]*redis.StringCmd, len(keys))
-	for i, key := range keys {
-		cmds[i] = pipe.Get(ctx, key)
+	timestamp, err := strconv.ParseInt(members[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing snapshot timestamp: %w", err)
 	}
 
-	// Execute pipeline - errors are handled per-command below
-	_, _ = pipe.Exec(ctx)
+	return timestamp, nil
+}
+
+// GetAll retrieves the metrics of the switches selected by the filter in the snapshot of the
+// given timestamp from Redis and returns them as a slice of maps
+// Each map contains a single key-value pair where the key is the switchID
+// and the value is the MetricRecord
+// The filter is applied before any record is fetched
+func (dao *DAOMetrics) GetAll(ctx context.Context,
+	timestamp int64,
+	filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	return dao.layout.getSnapshot(ctx, timestamp, filter)
+}
 
-	// Pre-allocate result slice
-	result := make([]map[string]telemetrics.MetricRecord, 0, len(keys))
+// GetMetric retrieves a specific metric value for a given switch from the snapshot
+// of the given timestamp in Redis
+// Returns the metric value as interface{}, or an error if switch or metric doesn't exist
+func (dao *DAOMetrics) GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error) {
+	record, err := dao.layout.getRecord(ctx, timestamp, switchID)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, cmd := range cmds {
-		data, err := cmd.Result()
-		if err != nil {
-			// Skip keys that don't exist or have errors
-			continue
-		}
+	// Check if the metric exists in the record
+	value, exists := record.GetMetricValue(metric)
+	if !exists {
+		return nil, ErrMetricNotFound
+	}
 
-		// Parse the JSON data into MetricRecord
-		var record telemetrics.MetricRecord
-		if err := json.Unmarshal([]byte(data), &record); err != nil {
-			fmt.Printf("Error parsing MetricRecord for key %s: %v\n", keys[i], err)
-			continue
-		}
+	return value, nil
+}
 
-		// Parse the key to extract switchID (remove timestamp prefix)
-		_, switchID, err := dao.parseMetricKey(keys[i])
-		if err != nil {
-			fmt.Printf("Error parsing key %s: %v\n", keys[i], err)
-			continue
+// GetMetrics retrieves the values of the given metrics for the given switches from the snapshot
+// of the given timestamp in Redis, using a single pipelined round-trip
+// The result maps each switchID to its values by metric name
+// Switches without a record in the snapshot are omitted
+func (dao *DAOMetrics) GetMetrics(ctx context.Context,
+	timestamp int64,
+	switchIDs []string,
+	metrics []string) (map[string]map[string]float64, error) {
+	for _, metric := range metrics {
+		if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+			return nil, ErrMetricNotFound
 		}
+	}
 
-		// Add to result as a map with single key-value pair using switchID only
-		result = append(result, map[string]telemetrics.MetricRecord{
-			switchID: record,
-		})
+	records, err := dao.layout.getRecords(ctx, timestamp, switchIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	return selectMetrics(records, metrics), nil
 }
 
-// GetMetric retrieves a specific metric value for a given key from Redis
-// Returns the metric value as interface{}, or an error if key or metric doesn't exist
-func (dao *DAOMetrics) GetMetric(ctx context.Context, switchID string, metric string) (interface{}, error) {
+// GetMetricHistory retrieves the values of a metric for a given switch from every stored
+// snapshot whose timestamp is within [from, to], ordered by timestamp ascending
+func (dao *DAOMetrics) GetMetricHistory(ctx context.Context,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) ([]telemetrics.MetricPoint, error) {
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	// Only committed snapshots are visible to readers
 	lastTimeUpdated, err := dao.getLastTimeUpdated(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving last update time: %w", err)
 	}
+	to = min(to, lastTimeUpdated)
 
-	// Build the key using last update time and switchID
-	key := dao.buildMetricKey(lastTimeUpdated, switchID)
-
-	// Get the value for this key
-	data, err := dao.redisClient.Get(ctx, key).Result()
+	series, err := dao.layout.getMetricSeries(ctx, switchID, metric, from, to)
 	if err != nil {
-		return nil, fmt.Errorf("switch_id does not exist")
+		return nil, err
 	}
 
-	// Unmarshal into a map to access individual fields
-	var metricMap map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &metricMap); err != nil {
-		return nil, fmt.Errorf("error parsing data for key %s: %w", key, err)
+	points, exists := series[switchID]
+	if !exists {
+		return []telemetrics.MetricPoint{}, nil
 	}
 
-	// Check if the metric exists in the map
-	value, exists := metricMap[metric]
-	if !exists {
-		return nil, fmt.Errorf("metric does not exist")
+	return points, nil
+}
+
+// GetAllMetricHistory retrieves the values of a metric for every switch from every stored
+// snapshot whose timestamp is within [from, to]
+// The result maps each switchID to its points, ordered by timestamp ascending
+func (dao *DAOMetrics) GetAllMetricHistory(ctx context.Context,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.MetricPoint, error) {
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
 	}
 
-	return value, nil
+	// Only committed snapshots are visible to readers
+	lastTimeUpdated, err := dao.getLastTimeUpdated(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving last update time: %w", err)
+	}
+	to = min(to, lastTimeUpdated)
+
+	return dao.layout.getMetricSeries(ctx, "", metric, from, to)
 }
 
 func (dao *DAOMetrics) getLastTimeUpdated(ctx context.Context) (int64, error) {
 	// Get last time updated value
 	data, err := dao.redisClient.Get(ctx, LastUpdateTimeKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrSnapshotNotFound
+	}
 	if err != nil {
 		return 0, fmt.Errorf("error retrieving last update time: %w", err)
 	}
@@ -173,17 +283,3 @@ func (dao *DAOMetrics) getLastTimeUpdated(ctx context.Context) (int64, error) {
 
 	return lastTimeUpdated, nil
 }
-
-func (dao *DAOMetrics) buildMetricKey(timestamp int64, switchID string) string {
-	return fmt.Sprintf("%d/%s", timestamp, switchID)
-}
-
-func (dao *DAOMetrics) parseMetricKey(key string) (int64, string, error) {
-	var timestamp int64
-	var switchID string
-	n, err := fmt.Sscanf(key, "%d/%s", &timestamp, &switchID)
-	if err != nil || n != 2 {
-		return 0, "", fmt.Errorf("invalid key format: %s", key)
-	}
-	return timestamp, switchID, nil
-}
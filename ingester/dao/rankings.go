@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// Rankings are stored independently of the raw layout: each metric of a snapshot is a sorted set
+// under rank/{timestamp}/{metric}, with switch ID members scored by the metric value, expiring
+// together with the raw data
+
+// queueRankings queues the commands adding the records of a snapshot batch to the rankings
+func (dao *DAOMetrics) queueRankings(ctx context.Context,
+	pipe redis.Pipeliner,
+	timestamp int64,
+	records map[string]telemetrics.MetricRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	for _, metric := range telemetrics.GetMetricNames() {
+		members := make([]redis.Z, 0, len(records))
+		for switchID, record := range records {
+			value, _ := record.GetMetricValue(metric)
+			members = append(members, redis.Z{Score: value, Member: switchID})
+		}
+
+		key := buildRankingKey(timestamp, metric)
+		pipe.ZAdd(ctx, key, members...)
+		pipe.Expire(ctx, key, dao.retention)
+	}
+}
+
+// GetTopK retrieves the k switches with the highest (desc) or lowest values of a metric
+// in the snapshot of the given timestamp, ordered by rank
+// A single range query is run on the ranking of the snapshot
+func (dao *DAOMetrics) GetTopK(ctx context.Context,
+	timestamp int64,
+	metric string,
+	k int,
+	desc bool) ([]telemetrics.RankedSwitch, error) {
+	if k <= 0 {
+		return nil, ErrInvalidTopK
+	}
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	key := buildRankingKey(timestamp, metric)
+	stop := int64(k - 1)
+
+	var members []redis.Z
+	var err error
+	if desc {
+		members, err = dao.redisClient.ZRevRangeWithScores(ctx, key, 0, stop).Result()
+	} else {
+		members, err = dao.redisClient.ZRangeWithScores(ctx, key, 0, stop).Result()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving ranking: %w", err)
+	}
+
+	result := make([]telemetrics.RankedSwitch, 0, len(members))
+	for _, member := range members {
+		result = append(result, telemetrics.RankedSwitch{
+			SwitchID: member.Member.(string),
+			Value:    member.Score,
+		})
+	}
+
+	return result, nil
+}
+
+// topK orders the ranking like the Redis sorted sets do, by value then by switch ID,
+// and returns its first k switches
+func topK(ranking []telemetrics.RankedSwitch, k int, desc bool) []telemetrics.RankedSwitch {
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Value != ranking[j].Value {
+			return (ranking[i].Value > ranking[j].Value) == desc
+		}
+		return (ranking[i].SwitchID > ranking[j].SwitchID) == desc
+	})
+
+	return ranking[:min(k, len(ranking))]
+}
+
+func buildRankingKey(timestamp int64, metric string) string {
+	return "rank/" + strconv.FormatInt(timestamp, 10) + "/" + metric
+}
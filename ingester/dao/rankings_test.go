@@ -0,0 +1,32 @@
+package dao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTopK_InvalidK(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryMetrics(time.Minute)
+	commitSnapshot(t, memory, 1000, testRecords())
+
+	// Every store rejects the ranking before reading it, the Redis one can't even be reached
+	stores := map[string]MetricStore{
+		"memory": memory,
+		"cached": NewCachedMetrics(memory, time.Minute, time.Minute),
+		"redis":  newUnreachableDAOMetrics(t),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []int{0, -1} {
+				ranking, err := store.GetTopK(ctx, 1000, "bandwidth_mbps", k, true)
+				assert.ErrorIs(t, err, ErrInvalidTopK, "k=%d", k)
+				assert.Nil(t, ranking)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/instrument"
+)
+
+var redisCommandDuration = instrument.NewHistogramVec("ingester_redis_command_duration_seconds",
+	"Redis command latency in seconds by command, pipelines are recorded as a single pipeline command.",
+	instrument.DefBuckets, "command")
+
+// RedisMetricsHook records the latency of every Redis command and pipeline
+// Register it with redisClient.AddHook
+type RedisMetricsHook struct{}
+
+var _ redis.Hook = RedisMetricsHook{}
+
+func (RedisMetricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (RedisMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		redisCommandDuration.Observe(time.Since(start).Seconds(), cmd.Name())
+		return err
+	}
+}
+
+func (RedisMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		redisCommandDuration.Observe(time.Since(start).Seconds(), "pipeline")
+		return err
+	}
+}
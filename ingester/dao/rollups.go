@@ -0,0 +1,208 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// Rollups are stored independently of the raw layout: each switch/metric pair of a resolution
+// is a sorted set under rollup/{resolution_seconds}/{switch_id}/{metric}, scored by the bucket
+// timestamp with "{timestamp}:{min}:{max}:{avg}:{count}" members
+// The last bucket rolled up per resolution is kept under rollup/{resolution_seconds}/last, so the
+// rollup job resumes where it stopped after a restart
+
+// AddRollups saves the rollups of a metric, keyed by switchID, for the given resolution
+// Buckets older than the retention are trimmed
+func (dao *DAOMetrics) AddRollups(ctx context.Context,
+	resolution time.Duration,
+	retention time.Duration,
+	metric string,
+	rollups map[string]telemetrics.RollupPoint) error {
+	switchesKey := buildRollupSwitchesKey(resolution)
+
+	// Use pipeline to write all rollups in a single round-trip
+	pipe := dao.redisClient.Pipeline()
+	for switchID, rollup := range rollups {
+		key := buildRollupKey(resolution, switchID, metric)
+		score := strconv.FormatInt(rollup.Timestamp, 10)
+		cutoff := "(" + strconv.FormatInt(rollup.Timestamp-int64(retention.Seconds()), 10)
+
+		// Replace the bucket if it was already rolled up
+		pipe.ZRemRangeByScore(ctx, key, score, score)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(rollup.Timestamp), Member: buildRollupMember(rollup)})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", cutoff)
+		pipe.Expire(ctx, key, retention)
+
+		pipe.ZAdd(ctx, switchesKey, redis.Z{Score: float64(rollup.Timestamp), Member: switchID})
+		pipe.ZRemRangeByScore(ctx, switchesKey, "-inf", cutoff)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("error executing pipeline: %w", err)
+	}
+
+	return nil
+}
+
+// GetRollupHistory retrieves the rollups of a metric for a given switch at the given resolution
+// whose bucket timestamp is within [from, to], ordered by timestamp ascending
+func (dao *DAOMetrics) GetRollupHistory(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) ([]telemetrics.RollupPoint, error) {
+	series, err := dao.getRollupSeries(ctx, resolution, []string{switchID}, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups, exists := series[switchID]
+	if !exists {
+		return []telemetrics.RollupPoint{}, nil
+	}
+
+	return rollups, nil
+}
+
+// GetAllRollupHistory retrieves the rollups of a metric for every switch at the given resolution
+// whose bucket timestamp is within [from, to]
+func (dao *DAOMetrics) GetAllRollupHistory(ctx context.Context,
+	resolution time.Duration,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.RollupPoint, error) {
+	// Switches are scored by the last bucket they were seen in
+	switchIDs, err := dao.redisClient.ZRangeByScore(ctx, buildRollupSwitchesKey(resolution), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return dao.getRollupSeries(ctx, resolution, switchIDs, metric, from, to)
+}
+
+// SetLastRollup records the start of the last bucket rolled up at the given resolution
+// The bucket is never moved back, so concurrent rollup jobs can't undo each other's progress
+func (dao *DAOMetrics) SetLastRollup(ctx context.Context, resolution time.Duration, bucket int64) error {
+	// Same compare-and-set as the snapshot pointer
+	return setLastUpdateTimeScript.Run(ctx, dao.redisClient, []string{buildLastRollupKey(resolution)}, bucket).Err()
+}
+
+// GetLastRollup returns the start of the last bucket rolled up at the given resolution, 0 if none
+func (dao *DAOMetrics) GetLastRollup(ctx context.Context, resolution time.Duration) (int64, error) {
+	bucket, err := dao.redisClient.Get(ctx, buildLastRollupKey(resolution)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return bucket, err
+}
+
+func (dao *DAOMetrics) getRollupSeries(ctx context.Context,
+	resolution time.Duration,
+	switchIDs []string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.RollupPoint, error) {
+	if _, ok := (telemetrics.MetricRecord{}).GetMetricValue(metric); !ok {
+		return nil, ErrMetricNotFound
+	}
+
+	series := make(map[string][]telemetrics.RollupPoint)
+	if len(switchIDs) == 0 {
+		return series, nil
+	}
+
+	// Use pipeline to fetch the range of every switch in batch
+	rangeBy := &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}
+	pipe := dao.redisClient.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(switchIDs))
+	for i, switchID := range switchIDs {
+		cmds[i] = pipe.ZRangeByScore(ctx, buildRollupKey(resolution, switchID, metric), rangeBy)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		// Members are returned ordered by score, i.e. by timestamp ascending
+		for _, member := range cmd.Val() {
+			rollup, err := parseRollupMember(member)
+			if err != nil {
+				fmt.Printf("Error parsing rollup member %s of switch %s: %v\n", member, switchIDs[i], err)
+				continue
+			}
+			series[switchIDs[i]] = append(series[switchIDs[i]], rollup)
+		}
+	}
+
+	return series, nil
+}
+
+func buildRollupKey(resolution time.Duration, switchID string, metric string) string {
+	return fmt.Sprintf("rollup/%d/%s/%s", int64(resolution.Seconds()), switchID, metric)
+}
+
+func buildRollupSwitchesKey(resolution time.Duration) string {
+	return fmt.Sprintf("rollup/%d/switches", int64(resolution.Seconds()))
+}
+
+func buildLastRollupKey(resolution time.Duration) string {
+	return fmt.Sprintf("rollup/%d/last", int64(resolution.Seconds()))
+}
+
+func buildRollupMember(rollup telemetrics.RollupPoint) string {
+	return strings.Join([]string{
+		strconv.FormatInt(rollup.Timestamp, 10),
+		strconv.FormatFloat(rollup.Min, 'f', -1, 64),
+		strconv.FormatFloat(rollup.Max, 'f', -1, 64),
+		strconv.FormatFloat(rollup.Avg, 'f', -1, 64),
+		strconv.Itoa(rollup.Count),
+	}, ":")
+}
+
+func parseRollupMember(member string) (telemetrics.RollupPoint, error) {
+	fields := strings.Split(member, ":")
+	if len(fields) != 5 {
+		return telemetrics.RollupPoint{}, fmt.Errorf("invalid rollup member format: %s", member)
+	}
+
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return telemetrics.RollupPoint{}, fmt.Errorf("invalid rollup timestamp: %w", err)
+	}
+
+	var values [3]float64
+	for i := range values {
+		values[i], err = strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			return telemetrics.RollupPoint{}, fmt.Errorf("invalid rollup value: %w", err)
+		}
+	}
+
+	count, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return telemetrics.RollupPoint{}, fmt.Errorf("invalid rollup count: %w", err)
+	}
+
+	return telemetrics.RollupPoint{
+		Timestamp: timestamp,
+		Min:       values[0],
+		Max:       values[1],
+		Avg:       values[2],
+		Count:     count,
+	}, nil
+}
@@ -0,0 +1,367 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// snapshotLayout stores each snapshot row as an independent JSON string under
+// {timestamp}/{switch_id}, expiring after the TTL
+type snapshotLayout struct {
+	redisClient redis.UniversalClient
+	ttl         time.Duration
+}
+
+func (l *snapshotLayout) queueMetric(ctx context.Context,
+	pipe redis.Pipeliner,
+	timestamp int64,
+	switchID string,
+	record telemetrics.MetricRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// Build the Redis key
+	key := l.buildMetricKey(timestamp, switchID)
+
+	// Store the JSON data in Redis with TTL
+	pipe.Set(ctx, key, data, l.ttl)
+	return nil
+}
+
+func (l *snapshotLayout) getSnapshot(ctx context.Context,
+	timestamp int64,
+	filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	// Build the pattern: <timestamp>/<switch_id pattern>
+	pattern := fmt.Sprintf("%d/%s", timestamp, filter.scanPattern())
+
+	keys, err := l.scanKeys(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep only the keys of the selected switches, the scan pattern may be broader than the filter
+	var selected []string
+	var switchIDs []string
+	for _, key := range keys {
+		// Parse the key to extract switchID (remove timestamp prefix)
+		_, switchID, err := l.parseMetricKey(key)
+		if err != nil {
+			fmt.Printf("Error parsing key %s: %v\n", key, err)
+			continue
+		}
+		if !filter.Match(switchID) {
+			continue
+		}
+		selected = append(selected, key)
+		switchIDs = append(switchIDs, switchID)
+	}
+
+	if len(selected) == 0 {
+		return []map[string]telemetrics.MetricRecord{}, nil
+	}
+
+	// Use pipeline to fetch all values in batch
+	pipe := l.redisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(selected))
+	for i, key := range selected {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+
+	// Execute pipeline - errors are handled per-command below
+	_, _ = pipe.Exec(ctx)
+
+	// Pre-allocate result slice
+	result := make([]map[string]telemetrics.MetricRecord, 0, len(selected))
+
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err != nil {
+			// Skip keys that don't exist or have errors
+			continue
+		}
+
+		// Parse the JSON data into MetricRecord
+		var record telemetrics.MetricRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			fmt.Printf("Error parsing MetricRecord for key %s: %v\n", selected[i], err)
+			continue
+		}
+
+		// Add to result as a map with single key-value pair using switchID only
+		result = append(result, map[string]telemetrics.MetricRecord{
+			switchIDs[i]: record,
+		})
+	}
+
+	return result, nil
+}
+
+func (l *snapshotLayout) getRecord(ctx context.Context, timestamp int64, switchID string) (telemetrics.MetricRecord, error) {
+	// Build the key using the snapshot timestamp and switchID
+	key := l.buildMetricKey(timestamp, switchID)
+
+	// Get the value for this key
+	data, err := l.redisClient.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return telemetrics.MetricRecord{}, ErrSwitchNotFound
+	}
+	if err != nil {
+		return telemetrics.MetricRecord{}, fmt.Errorf("error retrieving key %s: %w", key, err)
+	}
+
+	var record telemetrics.MetricRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return telemetrics.MetricRecord{}, fmt.Errorf("error parsing data for key %s: %w", key, err)
+	}
+
+	return record, nil
+}
+
+func (l *snapshotLayout) getRecords(ctx context.Context,
+	timestamp int64,
+	switchIDs []string) (map[string]telemetrics.MetricRecord, error) {
+	records := make(map[string]telemetrics.MetricRecord, len(switchIDs))
+	if len(switchIDs) == 0 {
+		return records, nil
+	}
+
+	// Use pipeline to fetch all values in batch
+	pipe := l.redisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(switchIDs))
+	for i, switchID := range switchIDs {
+		cmds[i] = pipe.Get(ctx, l.buildMetricKey(timestamp, switchID))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			// Skip switches without a record in the snapshot
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving record of switch %s: %w", switchIDs[i], err)
+		}
+
+		var record telemetrics.MetricRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			fmt.Printf("Error parsing MetricRecord of switch %s: %v\n", switchIDs[i], err)
+			continue
+		}
+		records[switchIDs[i]] = record
+	}
+
+	return records, nil
+}
+
+// getMetricSeries reads the records of the switch (or all switches) in the committed snapshots
+// within [from, to] and groups the values of the metric by switchID
+// The snapshots are taken from the snapshot index and their switches from the rankings of the
+// metric, so no key is scanned
+func (l *snapshotLayout) getMetricSeries(ctx context.Context,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.MetricPoint, error) {
+	snapshots, err := l.redisClient.ZRangeByScore(ctx, SnapshotsKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving snapshot index: %w", err)
+	}
+
+	snapshotTimestamps := make([]int64, 0, len(snapshots))
+	for _, member := range snapshots {
+		timestamp, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing snapshot timestamp: %w", err)
+		}
+		snapshotTimestamps = append(snapshotTimestamps, timestamp)
+	}
+
+	snapshotSwitches, err := l.getSnapshotSwitches(ctx, snapshotTimestamps, switchID, metric)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the keys of the records to read: <timestamp>/<switch_id>
+	var inRange []string
+	var timestamps []int64
+	var switchIDs []string
+	for i, timestamp := range snapshotTimestamps {
+		for _, keySwitchID := range snapshotSwitches[i] {
+			inRange = append(inRange, l.buildMetricKey(timestamp, keySwitchID))
+			timestamps = append(timestamps, timestamp)
+			switchIDs = append(switchIDs, keySwitchID)
+		}
+	}
+
+	series := make(map[string][]telemetrics.MetricPoint)
+	if len(inRange) == 0 {
+		return series, nil
+	}
+
+	// Use pipeline to fetch all values in batch
+	pipe := l.redisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(inRange))
+	for i, key := range inRange {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+
+	// Execute pipeline - errors are handled per-command below
+	_, _ = pipe.Exec(ctx)
+
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			// Skip switches missing from a snapshot, or records that expired since it was indexed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving key %s: %w", inRange[i], err)
+		}
+
+		var record telemetrics.MetricRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			fmt.Printf("Error parsing MetricRecord for key %s: %v\n", inRange[i], err)
+			continue
+		}
+
+		value, _ := record.GetMetricValue(metric)
+		series[switchIDs[i]] = append(series[switchIDs[i]], telemetrics.MetricPoint{
+			Timestamp: timestamps[i],
+			Value:     value,
+		})
+	}
+
+	for _, points := range series {
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Timestamp < points[j].Timestamp
+		})
+	}
+
+	return series, nil
+}
+
+// getSnapshotSwitches returns the switches of each of the given snapshots, only the given switch
+// if not empty
+// The ranking of a metric in a snapshot lists all its switches, and is read in a single pipeline
+func (l *snapshotLayout) getSnapshotSwitches(ctx context.Context,
+	timestamps []int64,
+	switchID string,
+	metric string) ([][]string, error) {
+	switches := make([][]string, len(timestamps))
+	if switchID != "" {
+		for i := range timestamps {
+			switches[i] = []string{switchID}
+		}
+		return switches, nil
+	}
+	if len(timestamps) == 0 {
+		return switches, nil
+	}
+
+	pipe := l.redisClient.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(timestamps))
+	for i, timestamp := range timestamps {
+		cmds[i] = pipe.ZRange(ctx, buildRankingKey(timestamp, metric), 0, -1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("error retrieving switches of snapshots: %w", err)
+	}
+
+	for i, cmd := range cmds {
+		switches[i] = cmd.Val()
+	}
+	return switches, nil
+}
+
+// scanKeys returns all keys matching the given pattern
+// Uses SCAN instead of KEYS to avoid blocking Redis
+// In cluster mode every master shard is scanned, since SCAN only covers a single node
+func (l *snapshotLayout) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := l.redisClient.(*redis.ClusterClient)
+	if !ok {
+		return scanNodeKeys(ctx, l.redisClient, pattern)
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+		shardKeys, err := scanNodeKeys(ctx, shard, pattern)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys = append(keys, shardKeys...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// scanNodeKeys returns all keys matching the given pattern on a single Redis node
+func scanNodeKeys(ctx context.Context, node redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	var cursor uint64
+	for {
+		var scanKeys []string
+		var err error
+		scanKeys, cursor, err = node.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, scanKeys...)
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, nil
+}
+
+func (l *snapshotLayout) buildMetricKey(timestamp int64, switchID string) string {
+	return fmt.Sprintf("%d/%s", timestamp, switchID)
+}
+
+func (l *snapshotLayout) parseMetricKey(key string) (int64, string, error) {
+	var timestamp int64
+	var switchID string
+	n, err := fmt.Sscanf(key, "%d/%s", &timestamp, &switchID)
+	if err != nil || n != 2 {
+		return 0, "", fmt.Errorf("invalid key format: %s", key)
+	}
+	return timestamp, switchID, nil
+}
+
+// escapeScanPattern escapes glob special characters so the value is matched literally by SCAN
+func escapeScanPattern(value string) string {
+	var sb strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
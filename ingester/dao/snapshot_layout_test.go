@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnreachableDAOMetrics returns a store whose Redis server can't be reached
+func newUnreachableDAOMetrics(t *testing.T) *DAOMetrics {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return NewDAOMetrics(client, time.Minute)
+}
+
+func TestSnapshotLayout_StorageErrorIsNotSwitchNotFound(t *testing.T) {
+	ctx := context.Background()
+	store := newUnreachableDAOMetrics(t)
+
+	_, err := store.GetMetric(ctx, 1000, "sw1", "latency_ms")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrSwitchNotFound)
+
+	_, err = store.GetMetrics(ctx, 1000, []string{"sw1"}, []string{"latency_ms"})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrSwitchNotFound)
+}
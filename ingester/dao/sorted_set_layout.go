@@ -0,0 +1,237 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const (
+	// SwitchesKey is a sorted set of switch IDs scored by the last timestamp they were seen
+	SwitchesKey = "series/switches"
+)
+
+// sortedSetLayout stores each switch/metric pair as a sorted set under
+// series/{switch_id}/{metric}, scored by timestamp with "{timestamp}:{value}" members
+// Entries older than the retention are trimmed on every write
+type sortedSetLayout struct {
+	redisClient redis.UniversalClient
+	retention   time.Duration
+}
+
+func (l *sortedSetLayout) queueMetric(ctx context.Context,
+	pipe redis.Pipeliner,
+	timestamp int64,
+	switchID string,
+	record telemetrics.MetricRecord) error {
+	score := float64(timestamp)
+	cutoff := "(" + strconv.FormatInt(timestamp-int64(l.retention.Seconds()), 10)
+
+	for _, metric := range telemetrics.GetMetricNames() {
+		value, _ := record.GetMetricValue(metric)
+		key := l.buildSeriesKey(switchID, metric)
+
+		// Replace any value already stored for this timestamp
+		pipe.ZRemRangeByScore(ctx, key, strconv.FormatInt(timestamp, 10), strconv.FormatInt(timestamp, 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: l.buildMember(timestamp, value)})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", cutoff)
+		pipe.Expire(ctx, key, l.retention)
+	}
+	pipe.ZAdd(ctx, SwitchesKey, redis.Z{Score: score, Member: switchID})
+	pipe.ZRemRangeByScore(ctx, SwitchesKey, "-inf", cutoff)
+
+	return nil
+}
+
+func (l *sortedSetLayout) getSnapshot(ctx context.Context,
+	timestamp int64,
+	filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error) {
+	seen, err := l.getSwitchesSince(ctx, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the series of the selected switches are fetched
+	switchIDs := make([]string, 0, len(seen))
+	for _, switchID := range seen {
+		if filter.Match(switchID) {
+			switchIDs = append(switchIDs, switchID)
+		}
+	}
+
+	records, err := l.getRecords(ctx, timestamp, switchIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]telemetrics.MetricRecord, 0, len(records))
+	for _, switchID := range switchIDs {
+		record, exists := records[switchID]
+		if !exists {
+			continue
+		}
+		result = append(result, map[string]telemetrics.MetricRecord{
+			switchID: record,
+		})
+	}
+
+	return result, nil
+}
+
+func (l *sortedSetLayout) getRecord(ctx context.Context, timestamp int64, switchID string) (telemetrics.MetricRecord, error) {
+	records, err := l.getRecords(ctx, timestamp, []string{switchID})
+	if err != nil {
+		return telemetrics.MetricRecord{}, err
+	}
+
+	record, exists := records[switchID]
+	if !exists {
+		return telemetrics.MetricRecord{}, ErrSwitchNotFound
+	}
+
+	return record, nil
+}
+
+func (l *sortedSetLayout) getMetricSeries(ctx context.Context,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.MetricPoint, error) {
+	switchIDs := []string{switchID}
+	if switchID == "" {
+		var err error
+		switchIDs, err = l.getSwitchesSince(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	series := make(map[string][]telemetrics.MetricPoint)
+	if len(switchIDs) == 0 {
+		return series, nil
+	}
+
+	// Use pipeline to fetch the range of every switch in batch
+	rangeBy := &redis.ZRangeBy{
+		Min: strconv.FormatInt(from, 10),
+		Max: strconv.FormatInt(to, 10),
+	}
+	pipe := l.redisClient.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(switchIDs))
+	for i, id := range switchIDs {
+		cmds[i] = pipe.ZRangeByScore(ctx, l.buildSeriesKey(id, metric), rangeBy)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		// Members are returned ordered by score, i.e. by timestamp ascending
+		for _, member := range cmd.Val() {
+			timestamp, value, err := l.parseMember(member)
+			if err != nil {
+				fmt.Printf("Error parsing member %s of switch %s: %v\n", member, switchIDs[i], err)
+				continue
+			}
+			series[switchIDs[i]] = append(series[switchIDs[i]], telemetrics.MetricPoint{
+				Timestamp: timestamp,
+				Value:     value,
+			})
+		}
+	}
+
+	return series, nil
+}
+
+// getRecords rebuilds the records of the given switches in the snapshot of the given timestamp
+// Switches without any value at that timestamp are omitted from the result
+func (l *sortedSetLayout) getRecords(ctx context.Context,
+	timestamp int64,
+	switchIDs []string) (map[string]telemetrics.MetricRecord, error) {
+	records := make(map[string]telemetrics.MetricRecord, len(switchIDs))
+	if len(switchIDs) == 0 {
+		return records, nil
+	}
+
+	metrics := telemetrics.GetMetricNames()
+	score := strconv.FormatInt(timestamp, 10)
+	rangeBy := &redis.ZRangeBy{Min: score, Max: score}
+
+	// Use pipeline to fetch every switch/metric pair in batch
+	pipe := l.redisClient.Pipeline()
+	cmds := make([][]*redis.StringSliceCmd, len(switchIDs))
+	for i, switchID := range switchIDs {
+		cmds[i] = make([]*redis.StringSliceCmd, len(metrics))
+		for j, metric := range metrics {
+			cmds[i][j] = pipe.ZRangeByScore(ctx, l.buildSeriesKey(switchID, metric), rangeBy)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, switchID := range switchIDs {
+		var record telemetrics.MetricRecord
+		found := false
+		for j, metric := range metrics {
+			members := cmds[i][j].Val()
+			if len(members) == 0 {
+				continue
+			}
+			_, value, err := l.parseMember(members[0])
+			if err != nil {
+				fmt.Printf("Error parsing member %s of switch %s: %v\n", members[0], switchID, err)
+				continue
+			}
+			record.SetMetricValue(metric, value)
+			found = true
+		}
+		if found {
+			records[switchID] = record
+		}
+	}
+
+	return records, nil
+}
+
+// getSwitchesSince returns the switches that were seen at or after the given timestamp
+func (l *sortedSetLayout) getSwitchesSince(ctx context.Context, timestamp int64) ([]string, error) {
+	return l.redisClient.ZRangeByScore(ctx, SwitchesKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(timestamp, 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (l *sortedSetLayout) buildSeriesKey(switchID string, metric string) string {
+	return fmt.Sprintf("series/%s/%s", switchID, metric)
+}
+
+func (l *sortedSetLayout) buildMember(timestamp int64, value float64) string {
+	return strconv.FormatInt(timestamp, 10) + ":" + strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (l *sortedSetLayout) parseMember(member string) (int64, float64, error) {
+	rawTimestamp, rawValue, found := strings.Cut(member, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid member format: %s", member)
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid member timestamp: %w", err)
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid member value: %w", err)
+	}
+
+	return timestamp, value, nil
+}
@@ -0,0 +1,76 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+var (
+	// ErrSwitchNotFound is returned when the switch has no record in the snapshot
+	ErrSwitchNotFound = errors.New("switch_id does not exist")
+	// ErrMetricNotFound is returned when the metric name is unknown
+	ErrMetricNotFound = errors.New("metric does not exist")
+	// ErrSnapshotNotFound is returned when no committed snapshot matches the request
+	ErrSnapshotNotFound = errors.New("snapshot does not exist")
+	// ErrInvalidTopK is returned when the number of switches of a ranking is not positive
+	ErrInvalidTopK = errors.New("k must be a positive integer")
+)
+
+// MetricStore is the storage backend for telemetry metrics
+// Implementations must be safe for concurrent use by the ETL and the API server
+type MetricStore interface {
+	// Ping checks the backend can be reached
+	Ping(ctx context.Context) error
+	// AddMetric saves the record of a switch under the snapshot of the given timestamp
+	AddMetric(ctx context.Context, timestamp int64, switchID string, record telemetrics.MetricRecord) error
+	// AddMetrics saves a batch of records, keyed by switchID, under the snapshot of the given timestamp
+	AddMetrics(ctx context.Context, timestamp int64, records map[string]telemetrics.MetricRecord) error
+	// SetLastUpdateTime atomically points readers to the snapshot of the given timestamp
+	// The pointer is only moved forward
+	SetLastUpdateTime(ctx context.Context, timestamp int64) error
+	// GetLastUpdateTime returns the timestamp of the latest committed snapshot
+	GetLastUpdateTime(ctx context.Context) (int64, error)
+	// GetSnapshotTimeAt returns the timestamp of the latest committed snapshot at or before at
+	GetSnapshotTimeAt(ctx context.Context, at int64) (int64, error)
+	// GetAll retrieves the records of the switches selected by the filter in the snapshot of the given timestamp
+	GetAll(ctx context.Context, timestamp int64, filter SwitchFilter) ([]map[string]telemetrics.MetricRecord, error)
+	// GetMetric retrieves a single metric value of a switch from the snapshot of the given timestamp
+	GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error)
+	// GetMetrics retrieves the values of the given metrics for the given switches from the snapshot of the given timestamp
+	GetMetrics(ctx context.Context, timestamp int64, switchIDs []string, metrics []string) (map[string]map[string]float64, error)
+	// GetTopK retrieves the k switches with the highest (desc) or lowest values of a metric
+	// in the snapshot of the given timestamp, ordered by rank
+	// Returns ErrInvalidTopK if k is not positive
+	GetTopK(ctx context.Context, timestamp int64, metric string, k int, desc bool) ([]telemetrics.RankedSwitch, error)
+	// GetMetricHistory retrieves the values of a metric for a switch within [from, to]
+	GetMetricHistory(ctx context.Context, switchID string, metric string, from int64, to int64) ([]telemetrics.MetricPoint, error)
+	// GetAllMetricHistory retrieves the values of a metric for every switch within [from, to]
+	GetAllMetricHistory(ctx context.Context, metric string, from int64, to int64) (map[string][]telemetrics.MetricPoint, error)
+	// AddRollups saves the rollups of a metric, keyed by switchID, at the given resolution
+	AddRollups(ctx context.Context, resolution time.Duration, retention time.Duration, metric string, rollups map[string]telemetrics.RollupPoint) error
+	// GetRollupHistory retrieves the rollups of a metric for a switch at the given resolution within [from, to]
+	GetRollupHistory(ctx context.Context, resolution time.Duration, switchID string, metric string, from int64, to int64) ([]telemetrics.RollupPoint, error)
+	// GetAllRollupHistory retrieves the rollups of a metric for every switch at the given resolution within [from, to]
+	GetAllRollupHistory(ctx context.Context, resolution time.Duration, metric string, from int64, to int64) (map[string][]telemetrics.RollupPoint, error)
+	// SetLastRollup records the start of the last bucket rolled up at the given resolution
+	// The bucket is only moved forward
+	SetLastRollup(ctx context.Context, resolution time.Duration, bucket int64) error
+	// GetLastRollup returns the start of the last bucket rolled up at the given resolution, 0 if none
+	GetLastRollup(ctx context.Context, resolution time.Duration) (int64, error)
+}
+
+// selectMetrics maps each switchID to the values of the given metrics in its record
+func selectMetrics(records map[string]telemetrics.MetricRecord, metrics []string) map[string]map[string]float64 {
+	result := make(map[string]map[string]float64, len(records))
+	for switchID, record := range records {
+		values := make(map[string]float64, len(metrics))
+		for _, metric := range metrics {
+			values[metric], _ = record.GetMetricValue(metric)
+		}
+		result[switchID] = values
+	}
+	return result
+}
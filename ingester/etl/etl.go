@@ -9,46 +9,104 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/ingester/notify"
+	"github.com/yaron8/telemetry-infra/instrument"
 	"github.com/yaron8/telemetry-infra/logi"
 	"github.com/yaron8/telemetry-infra/telemetrics"
 )
 
+var (
+	etlRuns = instrument.NewCounterVec("ingester_etl_runs_total",
+		"ETL runs by result: committed, not_modified or failed.", "result")
+	etlRunDuration = instrument.NewHistogramVec("ingester_etl_run_duration_seconds",
+		"ETL run duration in seconds, from fetching the generator to committing the snapshot.",
+		instrument.DefBuckets)
+	etlLines = instrument.NewCounterVec("ingester_etl_lines_total",
+		"CSV lines read by the ETL by result: parsed or failed.", "result")
+	etlLastCommit = instrument.NewGaugeVec("ingester_etl_last_commit_timestamp_seconds",
+		"Timestamp of the latest snapshot committed by this ETL, frozen while the ETL fails.")
+)
+
 type ETL struct {
-	dao          *dao.DAOMetrics
+	dao          dao.MetricStore
+	broker       notify.Broker
 	interval     time.Duration
 	generatorURL string
+	batchSize    int
 	logger       *slog.Logger
+	lastSuccess  atomic.Int64 // Unix nanoseconds of the end of the last successful run, 0 if none
 }
 
-func NewETL(dao *dao.DAOMetrics, interval time.Duration, generatorURL string) *ETL {
+func NewETL(dao dao.MetricStore,
+	broker notify.Broker,
+	interval time.Duration,
+	generatorURL string,
+	batchSize int) *ETL {
 	return &ETL{
 		dao:          dao,
+		broker:       broker,
 		interval:     interval,
 		generatorURL: generatorURL,
+		batchSize:    batchSize,
 		logger:       logi.GetLogger(),
 	}
 }
 
-func (etl *ETL) Run() {
+// Run runs the ETL every interval until the context is cancelled
+// A run in progress is aborted while fetching or parsing, but a commit in progress is finished,
+// so shutting down never leaves a half-written snapshot behind the pointer
+func (etl *ETL) Run(ctx context.Context) {
 	etl.logger.Info("ETL starting", "interval", etl.interval, "generator_url", etl.generatorURL)
 	for {
-		if err := etl.updateMetrics(); err != nil {
+		start := time.Now()
+		result, err := etl.updateMetrics(ctx)
+		if err != nil && ctx.Err() != nil {
+			etl.logger.Info("ETL run aborted on shutdown", "error", err)
+			return
+		}
+		if err != nil {
 			etl.logger.Error("Error updating metrics", "error", err)
+		} else {
+			etl.lastSuccess.Store(time.Now().UnixNano())
 		}
+		etlRuns.Inc(result)
+		etlRunDuration.Observe(time.Since(start).Seconds())
 
 		// Sleep until the next interval
-		time.Sleep(etl.interval)
+		select {
+		case <-ctx.Done():
+			etl.logger.Info("ETL stopped")
+			return
+		case <-time.After(etl.interval):
+		}
 	}
 }
 
-func (etl *ETL) updateMetrics() error {
-	resp, err := http.Get(etl.generatorURL + "/counters")
+// LastSuccess returns the end time of the last successful run, either committed or not modified
+// Returns the zero time if no run succeeded yet
+func (etl *ETL) LastSuccess() time.Time {
+	nanos := etl.lastSuccess.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// updateMetrics runs a single ETL pass and returns its result label for the runs counter
+func (etl *ETL) updateMetrics(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, etl.generatorURL+"/counters", nil)
+	if err != nil {
+		return "failed", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		etl.logger.Error("Error fetching metrics from generator in EP /counters", "error", err)
-		return fmt.Errorf("failed to fetch metrics: %w", err)
+		return "failed", fmt.Errorf("failed to fetch metrics: %w", err)
 	}
 
 	defer resp.Body.Close()
@@ -56,23 +114,26 @@ func (etl *ETL) updateMetrics() error {
 	switch resp.StatusCode {
 	case http.StatusNotModified:
 		// No logging on hot path - cache hit is normal
-		return nil
+		return "not_modified", nil
 	case http.StatusOK:
 		etl.logger.Info("Fetching new metrics from generator")
-		if err := etl.writeMetricsLineByLine(resp.Body); err != nil {
-			return fmt.Errorf("failed to write metrics: %w", err)
+		if err := etl.writeMetricsLineByLine(ctx, resp.Body); err != nil {
+			return "failed", fmt.Errorf("failed to write metrics: %w", err)
 		}
 	default:
 		etl.logger.Error("Unexpected status code from generator", "status_code", resp.StatusCode)
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "failed", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	return nil
+	return "committed", nil
 }
 
-func (etl *ETL) writeMetricsLineByLine(respBody io.ReadCloser) error {
+// writeMetricsLineByLine parses the CSV stream line by line into an in-memory snapshot,
+// then commits it to the store. Readers only ever see complete snapshots: the last update
+// time is moved only after every record has been stored successfully.
+// Cancelling the context aborts reading the body, but not a commit that has started
+func (etl *ETL) writeMetricsLineByLine(ctx context.Context, respBody io.ReadCloser) error {
 	scanner := bufio.NewScanner(respBody)
-	ctx := context.Background()
 
 	// Skip the header line
 	if !scanner.Scan() {
@@ -80,6 +141,8 @@ func (etl *ETL) writeMetricsLineByLine(respBody io.ReadCloser) error {
 		return nil
 	}
 
+	// Records grouped by timestamp, then by switchID
+	snapshot := make(map[int64]map[string]telemetrics.MetricRecord)
 	lastTimeUpdated := int64(0)
 	lineNumber := 1
 	errorCount := 0
@@ -97,37 +160,79 @@ func (etl *ETL) writeMetricsLineByLine(respBody io.ReadCloser) error {
 		switchID, timestamp, record, err := etl.parseCSVLine(line)
 		if err != nil {
 			errorCount++
+			etlLines.Inc("failed")
 			etl.logger.Error("Error parsing line", "line_number", lineNumber, "error", err)
 			continue
 		}
+		etlLines.Inc("parsed")
 
-		// Store the metric using the DAO
-		if err := etl.dao.AddMetric(ctx, timestamp, switchID, record); err != nil {
-			errorCount++
-			etl.logger.Error("Error storing metric", "line_number", lineNumber, "switch_id", switchID, "error", err)
-			continue
+		if snapshot[timestamp] == nil {
+			snapshot[timestamp] = make(map[string]telemetrics.MetricRecord)
 		}
+		snapshot[timestamp][switchID] = record
 
 		if timestamp > 0 {
 			lastTimeUpdated = timestamp
 		}
 	}
 
-	// Update key in Redis for last update time
-	if lastTimeUpdated > 0 {
-		if err := etl.dao.SetLastUpdateTime(ctx, lastTimeUpdated); err != nil {
-			return fmt.Errorf("failed to set last update time: %w", err)
-		}
-		etl.logger.Info("Metrics processed successfully",
-			"total_lines", lineNumber-1,
-			"errors", errorCount,
-			"last_timestamp", lastTimeUpdated)
-	} else {
+	if err := scanner.Err(); err != nil {
+		// Never commit a snapshot read from a truncated body
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if lastTimeUpdated == 0 {
 		etl.logger.Error("No valid timestamp found to update last update time")
+		return nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+	if err := etl.commitSnapshot(context.WithoutCancel(ctx), snapshot, lastTimeUpdated); err != nil {
+		return err
+	}
+
+	etl.logger.Info("Metrics processed successfully",
+		"total_lines", lineNumber-1,
+		"errors", errorCount,
+		"last_timestamp", lastTimeUpdated)
+
+	return nil
+}
+
+// commitSnapshot stores the buffered records in pipelined batches of batchSize
+// and then atomically swaps the last update time to the new snapshot
+// If any batch fails the pointer is left untouched, so the partial data is never served
+func (etl *ETL) commitSnapshot(ctx context.Context,
+	snapshot map[int64]map[string]telemetrics.MetricRecord,
+	lastTimeUpdated int64) error {
+	for timestamp, records := range snapshot {
+		batch := make(map[string]telemetrics.MetricRecord, etl.batchSize)
+		for switchID, record := range records {
+			batch[switchID] = record
+			if len(batch) < etl.batchSize {
+				continue
+			}
+			if err := etl.dao.AddMetrics(ctx, timestamp, batch); err != nil {
+				return fmt.Errorf("failed to store metrics batch: %w", err)
+			}
+			batch = make(map[string]telemetrics.MetricRecord, etl.batchSize)
+		}
+
+		if len(batch) > 0 {
+			if err := etl.dao.AddMetrics(ctx, timestamp, batch); err != nil {
+				return fmt.Errorf("failed to store metrics batch: %w", err)
+			}
+		}
+	}
+
+	// Update key in the store for last update time
+	if err := etl.dao.SetLastUpdateTime(ctx, lastTimeUpdated); err != nil {
+		return fmt.Errorf("failed to set last update time: %w", err)
+	}
+	etlLastCommit.Set(float64(lastTimeUpdated))
+
+	// The snapshot is committed even if watchers could not be notified
+	if err := etl.broker.Publish(ctx, lastTimeUpdated); err != nil {
+		etl.logger.Error("Error publishing snapshot commit", "timestamp", lastTimeUpdated, "error", err)
 	}
 
 	return nil
@@ -1,9 +1,13 @@
 package integration_tests
 
 import (
+	"bufio"
 	"encoding/json"
 	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -230,8 +234,8 @@ func (s *IntegrationTestSuite) TestGetMetricEndpoint_UnknownMetric() {
 	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetric endpoint")
 	defer resp.Body.Close()
 
-	// Assert status code is 404
-	assert.Equal(s.T(), http.StatusNotFound, resp.StatusCode, "Expected status code 404")
+	// Assert status code is 400, metric names are validated against the OpenAPI document
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
 
 	// Read response body
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -239,7 +243,7 @@ func (s *IntegrationTestSuite) TestGetMetricEndpoint_UnknownMetric() {
 
 	// Assert the error message
 	body := string(bodyBytes)
-	assert.Equal(s.T(), "metric does not exist\n", body, "Expected error message 'metric does not exist'")
+	assert.Contains(s.T(), body, `invalid metric parameter: "unknown_metric_test"`, "Expected the unknown metric to be reported")
 }
 
 // TestGetMetricEndpoint_UnknownSwitchId tests the /telemetry/GetMetric endpoint with unknown switch_id
@@ -252,8 +256,8 @@ func (s *IntegrationTestSuite) TestGetMetricEndpoint_UnknownSwitchIdAndMetric()
 	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetric endpoint")
 	defer resp.Body.Close()
 
-	// Assert status code is 404
-	assert.Equal(s.T(), http.StatusNotFound, resp.StatusCode, "Expected status code 404")
+	// Assert status code is 400, the metric name is rejected before the switch is looked up
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
 
 	// Read response body
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -261,5 +265,718 @@ func (s *IntegrationTestSuite) TestGetMetricEndpoint_UnknownSwitchIdAndMetric()
 
 	// Assert the error message
 	body := string(bodyBytes)
-	assert.Equal(s.T(), "switch_id does not exist\n", body, "Expected error message 'switch_id does not exist'")
+	assert.Contains(s.T(), body, "invalid metric parameter", "Expected the unknown metric to be reported")
+}
+
+// MetricPointData represents a single point returned by /telemetry/GetMetricHistory
+type MetricPointData struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// TestGetMetricHistoryEndpoint_Ordered tests the /telemetry/GetMetricHistory endpoint returns ordered points
+func (s *IntegrationTestSuite) TestGetMetricHistoryEndpoint_Ordered() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/GetMetricHistory?switch_id=sw5&metric=latency_ms")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetricHistory endpoint")
+	defer resp.Body.Close()
+
+	// Assert status code is 200
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	// Read response body
+	bodyBytes, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err, "Failed to read response body")
+
+	// Parse JSON response
+	var points []MetricPointData
+	err = json.Unmarshal(bodyBytes, &points)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	// The latest snapshot is always within the range
+	s.Require().NotEmpty(points, "Expected at least one point in the history")
+
+	// Assert points are ordered by timestamp
+	for i := 1; i < len(points); i++ {
+		assert.Less(s.T(), points[i-1].Timestamp, points[i].Timestamp, "Expected points ordered by timestamp")
+	}
+}
+
+// TestGetMetricHistoryEndpoint_InvalidRange tests the /telemetry/GetMetricHistory endpoint with from after to
+func (s *IntegrationTestSuite) TestGetMetricHistoryEndpoint_InvalidRange() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/GetMetricHistory?switch_id=sw5&metric=latency_ms&from=200&to=100")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetricHistory endpoint")
+	defer resp.Body.Close()
+
+	// Assert status code is 400
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
+}
+
+// AggregationData represents the statistics returned by /telemetry/Aggregate for a single switch
+type AggregationData struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Sum   float64 `json:"sum"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// TestAggregateEndpoint_SingleSwitch tests the /telemetry/Aggregate endpoint for a single switch
+func (s *IntegrationTestSuite) TestAggregateEndpoint_SingleSwitch() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/Aggregate?switch_id=sw5&metric=bandwidth_mbps&window=10m")
+	s.Require().NoError(err, "Failed to make request to /telemetry/Aggregate endpoint")
+	defer resp.Body.Close()
+
+	// Assert status code is 200
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	// Read response body
+	bodyBytes, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err, "Failed to read response body")
+
+	// Parse JSON response
+	var result map[string]AggregationData
+	err = json.Unmarshal(bodyBytes, &result)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	agg, exists := result["sw5"]
+	s.Require().True(exists, "Expected to find 'sw5' in the aggregation response")
+
+	// Validate the statistics are consistent with each other
+	assert.Greater(s.T(), agg.Count, 0, "count should be greater than 0")
+	assert.LessOrEqual(s.T(), agg.Min, agg.P50, "min should be less than or equal to p50")
+	assert.LessOrEqual(s.T(), agg.P50, agg.P95, "p50 should be less than or equal to p95")
+	assert.LessOrEqual(s.T(), agg.P95, agg.P99, "p95 should be less than or equal to p99")
+	assert.LessOrEqual(s.T(), agg.P99, agg.Max, "p99 should be less than or equal to max")
+	assert.InDelta(s.T(), agg.Sum/float64(agg.Count), agg.Mean, 0.0001, "mean should equal sum/count")
+}
+
+// TestAggregateEndpoint_UnknownMetric tests the /telemetry/Aggregate endpoint with unknown metric
+func (s *IntegrationTestSuite) TestAggregateEndpoint_UnknownMetric() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/Aggregate?metric=unknown_metric_test")
+	s.Require().NoError(err, "Failed to make request to /telemetry/Aggregate endpoint")
+	defer resp.Body.Close()
+
+	// Assert status code is 404
+	assert.Equal(s.T(), http.StatusNotFound, resp.StatusCode, "Expected status code 404")
+}
+
+// TestGetMetricHistoryEndpoint_ResolutionHeader tests the /telemetry/GetMetricHistory endpoint reports the selected resolution
+func (s *IntegrationTestSuite) TestGetMetricHistoryEndpoint_ResolutionHeader() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	// Default range is the raw retention, so the raw data is selected
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/GetMetricHistory?switch_id=sw5&metric=latency_ms")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetricHistory endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "raw", resp.Header.Get("X-Resolution"), "Expected raw resolution")
+
+	// Unknown rollup resolutions are rejected
+	resp, err = client.Get(ingesterBaseURL + "/telemetry/GetMetricHistory?switch_id=sw5&metric=latency_ms&resolution=7s")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetricHistory endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
+}
+
+// TestListMetricsEndpoint_PointInTime tests the /telemetry/ListMetrics endpoint reads the snapshot at or before the at parameter
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_PointInTime() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	// The latest snapshot is committed before now
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics?at=" + time.Now().UTC().Format(time.RFC3339))
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	// No snapshot was committed at the epoch
+	resp, err = client.Get(ingesterBaseURL + "/telemetry/ListMetrics?at=1")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusNotFound, resp.StatusCode, "Expected status code 404")
+
+	// Malformed instants are rejected
+	resp, err = client.Get(ingesterBaseURL + "/telemetry/ListMetrics?at=yesterday")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
+}
+
+// RankedSwitchData represents a single entry of a TopK ranking
+type RankedSwitchData struct {
+	SwitchID string  `json:"switch_id"`
+	Value    float64 `json:"value"`
+}
+
+// TestTopKEndpoint_Ordered tests the /telemetry/TopK endpoint returns at most k switches ordered by value
+func (s *IntegrationTestSuite) TestTopKEndpoint_Ordered() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/TopK?metric=latency_ms&k=5&order=desc")
+	s.Require().NoError(err, "Failed to make request to /telemetry/TopK endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var ranking []RankedSwitchData
+	err = json.NewDecoder(resp.Body).Decode(&ranking)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	assert.LessOrEqual(s.T(), len(ranking), 5, "Expected at most k switches")
+	for i := 1; i < len(ranking); i++ {
+		assert.GreaterOrEqual(s.T(), ranking[i-1].Value, ranking[i].Value, "Expected values ordered descending")
+	}
+}
+
+// TestTopKEndpoint_InvalidOrder tests the /telemetry/TopK endpoint rejects an unknown order
+func (s *IntegrationTestSuite) TestTopKEndpoint_InvalidOrder() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/TopK?metric=latency_ms&order=sideways")
+	s.Require().NoError(err, "Failed to make request to /telemetry/TopK endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
+}
+
+// TestListMetricsEndpoint_FilterAndProject tests the /telemetry/ListMetrics endpoint filters switches and projects fields
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_FilterAndProject() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics?prefix=sw1&fields=latency_ms")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var metrics []map[string]map[string]float64
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	s.Require().NoError(err, "Failed to parse JSON response")
+	s.Require().NotEmpty(metrics, "Expected switches starting with sw1")
+
+	for _, metric := range metrics {
+		for switchID, fields := range metric {
+			assert.True(s.T(), strings.HasPrefix(switchID, "sw1"), "Expected switch_id to start with sw1")
+			assert.Len(s.T(), fields, 1, "Expected only the projected field")
+			assert.Contains(s.T(), fields, "latency_ms", "Expected latency_ms field")
+		}
+	}
+}
+
+// TestListMetricsEndpoint_Pagination tests the /telemetry/ListMetrics endpoint pages through every switch once
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_Pagination() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 0; page < 100; page++ {
+		url := ingesterBaseURL + "/telemetry/ListMetrics?sort=latency_ms&order=desc&limit=10"
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+
+		resp, err := client.Get(url)
+		s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+		s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+		var metrics []map[string]MetricData
+		err = json.NewDecoder(resp.Body).Decode(&metrics)
+		resp.Body.Close()
+		s.Require().NoError(err, "Failed to parse JSON response")
+		assert.LessOrEqual(s.T(), len(metrics), 10, "Expected at most limit switches")
+
+		for _, metric := range metrics {
+			for switchID := range metric {
+				assert.False(s.T(), seen[switchID], "Expected each switch on a single page")
+				seen[switchID] = true
+			}
+		}
+
+		cursor = resp.Header.Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+
+	assert.Empty(s.T(), cursor, "Expected the last page to have no cursor")
+	assert.True(s.T(), seen["sw5"], "Expected to find 'sw5' in one of the pages")
+}
+
+// TestGetMetricsEndpoint_Batch tests the /telemetry/GetMetrics endpoint looks up many switches and metrics in one call
+func (s *IntegrationTestSuite) TestGetMetricsEndpoint_Batch() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	body := strings.NewReader(`{"switch_ids": ["sw1", "sw5", "unknown_switch_test"], "metrics": ["latency_ms", "packet_errors"]}`)
+	resp, err := client.Post(ingesterBaseURL+"/telemetry/GetMetrics", "application/json", body)
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var values map[string]map[string]float64
+	err = json.NewDecoder(resp.Body).Decode(&values)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	s.Require().Contains(values, "sw5", "Expected to find 'sw5' in the response")
+	assert.Len(s.T(), values["sw5"], 2, "Expected only the requested metrics")
+	assert.Contains(s.T(), values["sw5"], "latency_ms", "Expected latency_ms metric")
+	assert.NotContains(s.T(), values, "unknown_switch_test", "Expected unknown switches to be omitted")
+}
+
+// TestGetMetricsEndpoint_MissingSwitchId tests the /telemetry/GetMetrics endpoint requires at least one switch
+func (s *IntegrationTestSuite) TestGetMetricsEndpoint_MissingSwitchId() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/GetMetrics?metric=latency_ms")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetrics endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400")
+}
+
+// TestPrometheusEndpoint_Scrape tests the /metrics endpoint renders the latest snapshot in the Prometheus text format
+func (s *IntegrationTestSuite) TestPrometheusEndpoint_Scrape() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/metrics")
+	s.Require().NoError(err, "Failed to make request to /metrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.True(s.T(), strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4"), "Expected Prometheus text content type")
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err, "Failed to read response body")
+
+	// Every line is a HELP/TYPE comment or a sample with an optional timestamp
+	commentLine := regexp.MustCompile(`^# (HELP|TYPE) [a-zA-Z_:][a-zA-Z0-9_:]* .+$`)
+	sampleLine := regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? \S+( -?[0-9]+)?$`)
+	for _, line := range strings.Split(strings.TrimSuffix(string(bodyBytes), "\n"), "\n") {
+		assert.True(s.T(), commentLine.MatchString(line) || sampleLine.MatchString(line), "Unexpected exposition line: %s", line)
+	}
+
+	body := string(bodyBytes)
+	assert.Contains(s.T(), body, "# TYPE switch_latency_ms gauge", "Expected latency_ms gauge family")
+	assert.Contains(s.T(), body, `switch_latency_ms{switch_id="sw5"} `, "Expected latency_ms sample of sw5")
+	assert.Contains(s.T(), body, "switch_snapshot_timestamp_seconds ", "Expected snapshot timestamp sample")
+}
+
+// TestInternalMetricsEndpoint tests the /internal/metrics endpoint reports the served requests and the ETL runs
+func (s *IntegrationTestSuite) TestInternalMetricsEndpoint() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	resp.Body.Close()
+
+	// The request is recorded once its handler returns, which may be after the response was read
+	body := ""
+	assert.Eventually(s.T(), func() bool {
+		resp, err := client.Get(ingesterBaseURL + "/internal/metrics")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return false
+		}
+		body = string(bodyBytes)
+		return strings.Contains(body, `http_requests_total{route="/telemetry/ListMetrics"`)
+	}, 2*time.Second, 100*time.Millisecond, "Expected request count of /telemetry/ListMetrics")
+
+	assert.Contains(s.T(), body, `http_requests_total{route="/telemetry/ListMetrics",status="200"}`, "Expected request count of ListMetrics")
+	assert.Contains(s.T(), body, "# TYPE http_requests_in_flight gauge", "Expected in-flight requests gauge")
+	assert.Contains(s.T(), body, `ingester_etl_lines_total{result="parsed"}`, "Expected parsed ETL lines")
+	assert.Contains(s.T(), body, "# TYPE ingester_etl_run_duration_seconds histogram", "Expected ETL run duration histogram")
+}
+
+// TestWatchEndpoint_FirstEvent tests the /telemetry/Watch endpoint streams the current snapshot on connect
+func (s *IntegrationTestSuite) TestWatchEndpoint_FirstEvent() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/Watch?switch_id=sw5&metric=latency_ms")
+	s.Require().NoError(err, "Failed to make request to /telemetry/Watch endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "text/event-stream", resp.Header.Get("Content-Type"), "Expected event stream content type")
+
+	// Read the first event up to its data line
+	var event struct {
+		Timestamp int64                         `json:"timestamp"`
+		Switches  map[string]map[string]float64 `json:"switches"`
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+			s.Require().NoError(json.Unmarshal([]byte(data), &event), "Failed to parse event data")
+			break
+		}
+	}
+
+	assert.Greater(s.T(), event.Timestamp, int64(0), "Expected the snapshot timestamp")
+	s.Require().Contains(event.Switches, "sw5", "Expected to find 'sw5' in the event")
+	assert.Len(s.T(), event.Switches, 1, "Expected only the filtered switch")
+	assert.Len(s.T(), event.Switches["sw5"], 1, "Expected only the filtered metric")
+}
+
+// TestOpenAPIEndpoint tests the /openapi.json endpoint documents the telemetry endpoints
+func (s *IntegrationTestSuite) TestOpenAPIEndpoint() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/openapi.json")
+	s.Require().NoError(err, "Failed to make request to /openapi.json endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	assert.True(s.T(), strings.HasPrefix(doc.OpenAPI, "3."), "Expected an OpenAPI 3 document")
+	assert.Contains(s.T(), doc.Paths, "/health", "Expected /health to be documented")
+	assert.Contains(s.T(), doc.Paths, "/telemetry/ListMetrics", "Expected /telemetry/ListMetrics to be documented")
+	assert.Contains(s.T(), doc.Paths, "/telemetry/GetMetric", "Expected /telemetry/GetMetric to be documented")
+}
+
+// TestOpenAPIEndpoint_Security tests the /openapi.json endpoint documents the authentication schemes and scopes
+func (s *IntegrationTestSuite) TestOpenAPIEndpoint_Security() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/openapi.json")
+	s.Require().NoError(err, "Failed to make request to /openapi.json endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var doc struct {
+		Paths map[string]struct {
+			Get struct {
+				Security []map[string][]string `json:"security"`
+			} `json:"get"`
+		} `json:"paths"`
+		Components struct {
+			SecuritySchemes map[string]json.RawMessage `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	assert.Contains(s.T(), doc.Components.SecuritySchemes, "apiKey", "Expected the API key scheme to be documented")
+	assert.Contains(s.T(), doc.Components.SecuritySchemes, "bearerToken", "Expected the bearer token scheme to be documented")
+	assert.Contains(s.T(), doc.Paths["/telemetry/ListMetrics"].Get.Security, map[string][]string{"apiKey": {"read"}},
+		"Expected /telemetry/ListMetrics to require the read scope")
+	assert.Empty(s.T(), doc.Paths["/health"].Get.Security, "Expected /health to be open")
+}
+
+// TestListMetricsEndpoint_InvalidParams tests the /telemetry/ListMetrics endpoint rejects parameters not matching the OpenAPI document
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_InvalidParams() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	for _, query := range []string{"fields=unknown_metric_test", "limit=abc", "limit=0", "sort=unknown_metric_test", "at=yesterday"} {
+		resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics?" + query)
+		s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+		resp.Body.Close()
+
+		assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode, "Expected status code 400 for %s", query)
+	}
+}
+
+// TestListMetricsEndpoint_CSV tests the /telemetry/ListMetrics endpoint returns CSV rows when accepted
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_CSV() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ingesterBaseURL+"/telemetry/ListMetrics?fields=latency_ms", nil)
+	s.Require().NoError(err, "Failed to create request")
+	req.Header.Set("Accept", "text/csv")
+
+	resp, err := client.Do(req)
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "text/csv", resp.Header.Get("Content-Type"), "Expected CSV content type")
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	s.Require().NoError(err, "Failed to read response body")
+
+	lines := strings.Split(strings.TrimSpace(string(bodyBytes)), "\n")
+	s.Require().Greater(len(lines), 1, "Expected a header and rows")
+	assert.Equal(s.T(), "switch_id,latency_ms", lines[0], "Expected the CSV header")
+	assert.True(s.T(), strings.HasPrefix(lines[1], "sw1,"), "Expected sw1 as the first row")
+	for _, line := range lines[1:] {
+		assert.Len(s.T(), strings.Split(line, ","), 2, "Expected switch_id and latency_ms columns")
+	}
+}
+
+// TestListMetricsEndpoint_NDJSON tests the /telemetry/ListMetrics endpoint returns a flat object per line with format=ndjson
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_NDJSON() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics?format=ndjson")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "application/x-ndjson", resp.Header.Get("Content-Type"), "Expected NDJSON content type")
+
+	rows := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		s.Require().NoError(json.Unmarshal(scanner.Bytes(), &row), "Failed to parse NDJSON line")
+		assert.Contains(s.T(), row, "switch_id", "Expected switch_id field")
+		assert.Contains(s.T(), row, "latency_ms", "Expected latency_ms field")
+		rows++
+	}
+	assert.Greater(s.T(), rows, 0, "Expected at least one row")
+}
+
+// TestListMetricsEndpoint_Object tests the /telemetry/ListMetrics endpoint returns an object keyed by switch ID with format=object
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_Object() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics?format=object")
+	s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	var metrics map[string]MetricData
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	s.Require().NoError(err, "Failed to parse JSON response")
+	assert.Contains(s.T(), metrics, "sw5", "Expected to find 'sw5' in the response")
+}
+
+// EnvelopeData represents the versioned response envelope
+type EnvelopeData struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Meta struct {
+		SnapshotTimestamp int64  `json:"snapshot_ts"`
+		DataAgeSeconds    *int64 `json:"data_age_seconds"`
+		Source            string `json:"source"`
+		RequestID         string `json:"request_id"`
+	} `json:"meta"`
+}
+
+// TestGetMetricEndpoint_Envelope tests the /telemetry/GetMetric endpoint wraps the value in the envelope when accepted
+func (s *IntegrationTestSuite) TestGetMetricEndpoint_Envelope() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ingesterBaseURL+"/telemetry/GetMetric?switch_id=sw5&metric=latency_ms", nil)
+	s.Require().NoError(err, "Failed to create request")
+	req.Header.Set("Accept", "application/vnd.telemetry.v1+json")
+	req.Header.Set("X-Request-ID", "test-request-1")
+
+	resp, err := client.Do(req)
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetric endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "test-request-1", resp.Header.Get("X-Request-ID"), "Expected the request ID to be echoed")
+
+	var envelope EnvelopeData
+	err = json.NewDecoder(resp.Body).Decode(&envelope)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	var latency float64
+	s.Require().NoError(json.Unmarshal(envelope.Data, &latency), "Failed to parse data as float64")
+	assert.Equal(s.T(), 1, envelope.Version, "Expected envelope version 1")
+	assert.Greater(s.T(), envelope.Meta.SnapshotTimestamp, int64(0), "Expected the snapshot timestamp")
+	s.Require().NotNil(envelope.Meta.DataAgeSeconds, "Expected the data age")
+	assert.GreaterOrEqual(s.T(), *envelope.Meta.DataAgeSeconds, int64(0), "Expected a non-negative data age")
+	assert.NotEmpty(s.T(), envelope.Meta.Source, "Expected the source generator")
+	assert.Equal(s.T(), "test-request-1", envelope.Meta.RequestID, "Expected the request ID")
+}
+
+// TestListMetricsEndpoint_NotModified tests the /telemetry/ListMetrics endpoint answers 304 to a client having the snapshot
+func (s *IntegrationTestSuite) TestListMetricsEndpoint_NotModified() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	// A snapshot may be committed between the two requests, retry on a new one
+	for attempt := 0; attempt < 3; attempt++ {
+		resp, err := client.Get(ingesterBaseURL + "/telemetry/ListMetrics")
+		s.Require().NoError(err, "Failed to make request to telemetry/ListMetrics endpoint")
+		resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+		etag := resp.Header.Get("ETag")
+		s.Require().NotEmpty(etag, "Expected an ETag")
+		s.Require().NotEmpty(resp.Header.Get("Last-Modified"), "Expected a Last-Modified header")
+
+		req, err := http.NewRequest(http.MethodGet, ingesterBaseURL+"/telemetry/ListMetrics", nil)
+		s.Require().NoError(err, "Failed to create request")
+		req.Header.Set("If-None-Match", etag)
+
+		resp, err = client.Do(req)
+		s.Require().NoError(err, "Failed to make conditional request to telemetry/ListMetrics endpoint")
+		resp.Body.Close()
+
+		if resp.Header.Get("ETag") != etag {
+			continue
+		}
+		assert.Equal(s.T(), http.StatusNotModified, resp.StatusCode, "Expected status code 304")
+		return
+	}
+
+	s.Fail("Expected the snapshot to be stable across two requests")
+}
+
+// TestGetMetricEndpoint_EnvelopeError tests the /telemetry/GetMetric endpoint reports errors with stable codes
+func (s *IntegrationTestSuite) TestGetMetricEndpoint_EnvelopeError() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	tests := map[string]string{
+		"/telemetry/GetMetric?envelope=v1&switch_id=unknown_sw&metric=bandwidth_mbps": "SWITCH_NOT_FOUND",
+		"/telemetry/GetMetric?envelope=v1&switch_id=sw5&metric=unknown_metric_test":   "INVALID_PARAMETER",
+		"/telemetry/GetMetric?envelope=v1&metric=bandwidth_mbps":                      "INVALID_PARAMETER",
+	}
+
+	for path, code := range tests {
+		resp, err := client.Get(ingesterBaseURL + path)
+		s.Require().NoError(err, "Failed to make request to /telemetry/GetMetric endpoint")
+
+		var envelope EnvelopeData
+		err = json.NewDecoder(resp.Body).Decode(&envelope)
+		resp.Body.Close()
+		s.Require().NoError(err, "Failed to parse JSON response of %s", path)
+
+		s.Require().NotNil(envelope.Error, "Expected an error for %s", path)
+		assert.Equal(s.T(), code, envelope.Error.Code, "Expected error code of %s", path)
+		assert.Equal(s.T(), code, resp.Header.Get("X-Error-Code"), "Expected error code header of %s", path)
+		assert.NotEmpty(s.T(), envelope.Meta.RequestID, "Expected a generated request ID")
+	}
+}
+
+// TestGetMetricEndpoint_DataAge tests the /telemetry/GetMetric endpoint reports the age of a fresh snapshot without a warning
+func (s *IntegrationTestSuite) TestGetMetricEndpoint_DataAge() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/telemetry/GetMetric?switch_id=sw5&metric=latency_ms")
+	s.Require().NoError(err, "Failed to make request to /telemetry/GetMetric endpoint")
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusOK, resp.StatusCode, "Expected status code 200")
+
+	age, err := strconv.Atoi(resp.Header.Get("X-Data-Age"))
+	s.Require().NoError(err, "Expected the data age header")
+	assert.GreaterOrEqual(s.T(), age, 0, "Expected a non-negative data age")
+	assert.Empty(s.T(), resp.Header.Get("Warning"), "Expected no stale warning on a fresh snapshot")
+}
+
+// TestReadyzEndpoint tests the /readyz endpoint reports every dependency of the ingester as ready
+func (s *IntegrationTestSuite) TestReadyzEndpoint() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/readyz")
+	s.Require().NoError(err, "Failed to make request to /readyz endpoint")
+	defer resp.Body.Close()
+
+	var report struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"checks"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	s.Require().NoError(err, "Failed to parse JSON response")
+
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode, "Expected status code 200")
+	assert.Equal(s.T(), "ok", report.Status, "Expected ok status")
+	for _, check := range []string{"storage", "etl", "snapshot"} {
+		s.Require().Contains(report.Checks, check, "Expected the %s check", check)
+		assert.Equal(s.T(), "ok", report.Checks[check].Status, "Expected the %s check to pass: %s", check, report.Checks[check].Error)
+	}
+}
+
+// TestLivezEndpoint tests the /livez endpoint reports the ingester as live
+func (s *IntegrationTestSuite) TestLivezEndpoint() {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Get(ingesterBaseURL + "/livez")
+	s.Require().NoError(err, "Failed to make request to /livez endpoint")
+	defer resp.Body.Close()
+
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode, "Expected status code 200")
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os/signal"
+	"syscall"
 
 	"github.com/yaron8/telemetry-infra/ingester/bootstrap"
 )
@@ -12,7 +15,11 @@ func main() {
 		panic(fmt.Sprintf("Failed to create ingester bootstrap: %v", err))
 	}
 
-	if err := bootstrap.Start(); err != nil {
-		panic(fmt.Sprintf("Failed to start ingester server: %v", err))
+	// Shut down gracefully on Ctrl+C and on the SIGTERM sent by Docker and Kubernetes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := bootstrap.Start(ctx); err != nil {
+		panic(fmt.Sprintf("Ingester server failed: %v", err))
 	}
 }
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Broker fans out snapshot commit notifications to subscribers
+type Broker interface {
+	// Publish notifies every subscriber that the snapshot of the given timestamp was committed
+	Publish(ctx context.Context, timestamp int64) error
+	// Subscribe returns a channel receiving the timestamps of committed snapshots
+	// and a function releasing the subscription
+	// A slow subscriber only receives the latest timestamp, older ones are dropped
+	Subscribe() (<-chan int64, func())
+}
+
+var _ Broker = (*LocalBroker)(nil)
+
+// LocalBroker fans out notifications published within the process
+type LocalBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan int64]struct{}
+	last        int64
+}
+
+// NewLocalBroker creates a new in-process broker
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{
+		subscribers: make(map[chan int64]struct{}),
+	}
+}
+
+// Publish notifies every subscriber of the process
+// Timestamps that are not newer than the last one published are ignored
+func (b *LocalBroker) Publish(ctx context.Context, timestamp int64) error {
+	b.fanOut(timestamp)
+	return nil
+}
+
+func (b *LocalBroker) Subscribe() (<-chan int64, func()) {
+	ch := make(chan int64, 1)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers, ch)
+	}
+}
+
+// fanOut delivers the timestamp to every subscriber without blocking,
+// replacing a timestamp the subscriber has not received yet
+func (b *LocalBroker) fanOut(timestamp int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Several instances may commit the same snapshot
+	if timestamp <= b.last {
+		return
+	}
+	b.last = timestamp
+
+	for ch := range b.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- timestamp
+	}
+}
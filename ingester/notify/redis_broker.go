@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yaron8/telemetry-infra/logi"
+)
+
+// CommitsChannel is the Redis pub/sub channel snapshot commits are published on
+const CommitsChannel = "snapshot_commits"
+
+var _ Broker = (*RedisBroker)(nil)
+
+// RedisBroker publishes notifications on a Redis pub/sub channel, so the subscribers of every
+// ingester instance are notified of a commit made by any of them
+type RedisBroker struct {
+	*LocalBroker
+	redisClient redis.UniversalClient
+	logger      *slog.Logger
+}
+
+// NewRedisBroker creates a new broker backed by Redis pub/sub
+// Listen must be running for the subscribers to be notified
+func NewRedisBroker(redisClient redis.UniversalClient) *RedisBroker {
+	return &RedisBroker{
+		LocalBroker: NewLocalBroker(),
+		redisClient: redisClient,
+		logger:      logi.GetLogger(),
+	}
+}
+
+// Publish publishes the timestamp on the commits channel
+// The local subscribers are notified by Listen, like the ones of every other instance
+func (b *RedisBroker) Publish(ctx context.Context, timestamp int64) error {
+	return b.redisClient.Publish(ctx, CommitsChannel, timestamp).Err()
+}
+
+// Listen fans out the timestamps received on the commits channel to the local subscribers
+// until the context is cancelled
+func (b *RedisBroker) Listen(ctx context.Context) {
+	pubsub := b.redisClient.Subscribe(ctx, CommitsChannel)
+	defer pubsub.Close()
+
+	b.logger.Info("Listening for snapshot commits", "channel", CommitsChannel)
+
+	// The channel reconnects and resubscribes on its own when the connection drops
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			timestamp, err := strconv.ParseInt(msg.Payload, 10, 64)
+			if err != nil {
+				b.logger.Error("Invalid snapshot commit notification", "payload", msg.Payload, "error", err)
+				continue
+			}
+			b.fanOut(timestamp)
+		}
+	}
+}
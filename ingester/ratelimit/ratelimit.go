@@ -0,0 +1,123 @@
+// Package ratelimit limits the request rate of each client with token buckets, and the number
+// of requests served concurrently with a semaphore
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket per client, refilled at rate tokens per second up to burst tokens
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter allowing each client rate requests per second with bursts of burst requests
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of the client
+// Returns false and how long until a token is available if the bucket is empty
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets refilled to the burst, which are the same as a new bucket,
+// so clients seen once do not accumulate
+func (l *Limiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// ConcurrencyLimiter bounds the number of requests served at once
+type ConcurrencyLimiter struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter serving up to limit requests at once,
+// each waiting up to maxWait for a slot
+func NewConcurrencyLimiter(limit int, maxWait time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		slots:   make(chan struct{}, limit),
+		maxWait: maxWait,
+	}
+}
+
+// Acquire waits up to maxWait for a slot, returning false if none was freed or ctx is done
+// The slot must be given back with Release
+func (c *ConcurrencyLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if c.maxWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release gives back a slot taken by Acquire
+func (c *ConcurrencyLimiter) Release() {
+	<-c.slots
+}
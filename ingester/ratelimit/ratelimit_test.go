@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a manually advanced clock for the Limiter
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewLimiter(rate, burst)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestLimiter_Burst(t *testing.T) {
+	l, _ := newTestLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok, "request %d of the burst", i)
+	}
+
+	ok, retryAfter := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Clients have their own buckets
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+}
+
+func TestLimiter_Refill(t *testing.T) {
+	l, clock := newTestLimiter(2, 2)
+
+	l.Allow("a")
+	l.Allow("a")
+	ok, retryAfter := l.Allow("a")
+	require.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// A quarter of a second refills half a token
+	clock.Advance(250 * time.Millisecond)
+	ok, retryAfter = l.Allow("a")
+	require.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, retryAfter)
+
+	clock.Advance(250 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// The bucket never holds more than the burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("a")
+		require.True(t, ok)
+	}
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+}
+
+func TestLimiter_RetryAfterBelowOneRequestPerSecond(t *testing.T) {
+	l, _ := newTestLimiter(0.1, 1)
+
+	l.Allow("a")
+	ok, retryAfter := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+}
+
+func TestLimiter_SweepsRefilledBuckets(t *testing.T) {
+	l, clock := newTestLimiter(1, 2)
+
+	l.Allow("idle")
+	l.Allow("busy")
+	require.Len(t, l.buckets, 2)
+
+	// Half of the refill time, nothing is swept
+	clock.Advance(time.Second)
+	l.Allow("busy")
+	assert.Len(t, l.buckets, 2)
+
+	// The idle bucket is full again, the busy one was used a second ago
+	clock.Advance(time.Second)
+	l.Allow("other")
+	assert.NotContains(t, l.buckets, "idle")
+	assert.Contains(t, l.buckets, "busy")
+	assert.Contains(t, l.buckets, "other")
+
+	// A swept client starts again with a full bucket
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("idle")
+		require.True(t, ok)
+	}
+}
+
+func TestConcurrencyLimiter_AcquireRelease(t *testing.T) {
+	c := NewConcurrencyLimiter(2, 0)
+	ctx := context.Background()
+
+	require.True(t, c.Acquire(ctx))
+	require.True(t, c.Acquire(ctx))
+	assert.False(t, c.Acquire(ctx), "no slot is left and there is no wait")
+
+	c.Release()
+	assert.True(t, c.Acquire(ctx))
+}
+
+func TestConcurrencyLimiter_WaitTimesOut(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 50*time.Millisecond)
+	ctx := context.Background()
+	require.True(t, c.Acquire(ctx))
+
+	start := time.Now()
+	assert.False(t, c.Acquire(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestConcurrencyLimiter_WaitGetsReleasedSlot(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 5*time.Second)
+	ctx := context.Background()
+	require.True(t, c.Acquire(ctx))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Release()
+	}()
+
+	start := time.Now()
+	assert.True(t, c.Acquire(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestConcurrencyLimiter_WaitCancelled(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 5*time.Second)
+	require.True(t, c.Acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.False(t, c.Acquire(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,159 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/ingester/aggregate"
+	"github.com/yaron8/telemetry-infra/ingester/config"
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/logi"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// Rollup is a background job downsampling the raw data into the configured rollup tiers
+// The finest tier is computed from the raw data and every coarser tier from the previous one
+// The last bucket rolled up per tier is recorded in the store, so a restarted job resumes
+// where it stopped instead of rolling up completed buckets again from partially expired data
+type Rollup struct {
+	dao          dao.MetricStore
+	rawRetention time.Duration
+	tiers        []config.RollupTier
+	interval     time.Duration
+	delay        time.Duration
+	// lastBuckets holds the last bucket rolled up per tier resolution, loaded from the store on the first run
+	lastBuckets map[time.Duration]int64
+	logger      *slog.Logger
+}
+
+// NewRollup creates a new rollup job
+// A bucket is rolled up once delay has passed since its end, so late snapshots are included
+func NewRollup(dao dao.MetricStore,
+	rawRetention time.Duration,
+	tiers []config.RollupTier,
+	interval time.Duration,
+	delay time.Duration) *Rollup {
+	return &Rollup{
+		dao:          dao,
+		rawRetention: rawRetention,
+		tiers:        tiers,
+		interval:     interval,
+		delay:        delay,
+		lastBuckets:  make(map[time.Duration]int64),
+		logger:       logi.GetLogger(),
+	}
+}
+
+// Run rolls up the completed buckets every interval until the context is cancelled
+// A bucket being rolled up when the context is cancelled is abandoned
+func (r *Rollup) Run(ctx context.Context) {
+	r.logger.Info("Rollup starting", "interval", r.interval, "tiers", len(r.tiers))
+	for {
+		r.rollupCompletedBuckets(ctx)
+
+		// Sleep until the next interval
+		select {
+		case <-ctx.Done():
+			r.logger.Info("Rollup stopped")
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// rollupCompletedBuckets rolls up every bucket completed since the last run, tier by tier
+// Finer tiers are processed first so coarser buckets see all of their finer buckets
+func (r *Rollup) rollupCompletedBuckets(ctx context.Context) {
+	now := time.Now().Add(-r.delay).Unix()
+
+	for i, tier := range r.tiers {
+		resolution := int64(tier.Resolution.Seconds())
+		// Start of the latest bucket that ended before now
+		lastCompleted := now - now%resolution - resolution
+
+		last, exists := r.lastBuckets[tier.Resolution]
+		if !exists {
+			var err error
+			if last, err = r.dao.GetLastRollup(ctx, tier.Resolution); err != nil {
+				// Rolling up without knowing what was done could overwrite complete buckets
+				r.logger.Error("Error reading the last rolled up bucket", "resolution", tier.Resolution, "error", err)
+				continue
+			}
+			r.lastBuckets[tier.Resolution] = last
+		}
+
+		// Without any progress recorded only the latest completed bucket is rolled up,
+		// otherwise the buckets after the last one, skipping those whose source data has expired
+		bucket := lastCompleted
+		if last > 0 {
+			oldest := now - int64(r.sourceRetention(i).Seconds())
+			bucket = max(last+resolution, oldest-oldest%resolution)
+		}
+
+		for ; bucket <= lastCompleted; bucket += resolution {
+			if err := r.rollupBucket(ctx, i, bucket); err != nil {
+				// Retry this bucket on the next run
+				r.logger.Error("Error rolling up bucket", "resolution", tier.Resolution, "bucket", bucket, "error", err)
+				break
+			}
+			if err := r.dao.SetLastRollup(ctx, tier.Resolution, bucket); err != nil {
+				r.logger.Error("Error recording the last rolled up bucket", "resolution", tier.Resolution, "bucket", bucket, "error", err)
+				break
+			}
+			r.lastBuckets[tier.Resolution] = bucket
+		}
+	}
+}
+
+// sourceRetention returns how long the data a tier is computed from is kept
+func (r *Rollup) sourceRetention(tierIndex int) time.Duration {
+	if tierIndex == 0 {
+		return r.rawRetention
+	}
+	return r.tiers[tierIndex-1].Retention
+}
+
+// rollupBucket computes the rollups of every metric and switch for the bucket of the given tier
+func (r *Rollup) rollupBucket(ctx context.Context, tierIndex int, bucket int64) error {
+	tier := r.tiers[tierIndex]
+	end := bucket + int64(tier.Resolution.Seconds()) - 1
+
+	for _, metric := range telemetrics.GetMetricNames() {
+		rollups := make(map[string]telemetrics.RollupPoint)
+
+		if tierIndex == 0 {
+			series, err := r.dao.GetAllMetricHistory(ctx, metric, bucket, end)
+			if err != nil {
+				return fmt.Errorf("failed to read raw data: %w", err)
+			}
+			for switchID, points := range series {
+				if len(points) > 0 {
+					rollups[switchID] = aggregate.Rollup(bucket, points)
+				}
+			}
+		} else {
+			finer := r.tiers[tierIndex-1].Resolution
+			series, err := r.dao.GetAllRollupHistory(ctx, finer, metric, bucket, end)
+			if err != nil {
+				return fmt.Errorf("failed to read %s rollups: %w", finer, err)
+			}
+			for switchID, points := range series {
+				if len(points) > 0 {
+					rollups[switchID] = aggregate.MergeRollups(bucket, points)
+				}
+			}
+		}
+
+		if len(rollups) == 0 {
+			continue
+		}
+
+		if err := r.dao.AddRollups(ctx, tier.Resolution, tier.Retention, metric, rollups); err != nil {
+			return fmt.Errorf("failed to store rollups: %w", err)
+		}
+	}
+
+	return nil
+}
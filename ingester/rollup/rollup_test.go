@@ -0,0 +1,107 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/ingester/config"
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/logi"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "ingester-rollup-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, err := logi.NewLog(&logi.Config{LogDir: logDir}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = logi.Close()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+var testTiers = []config.RollupTier{{Resolution: time.Minute, Retention: time.Hour}}
+
+// newTestStore returns a store holding a snapshot every 10 seconds over the last 3 minutes
+func newTestStore(t *testing.T) *dao.MemoryMetrics {
+	t.Helper()
+	ctx := context.Background()
+	store := dao.NewMemoryMetrics(time.Hour)
+
+	now := time.Now().Unix()
+	for timestamp := now - 180; timestamp <= now; timestamp += 10 {
+		require.NoError(t, store.AddMetrics(ctx, timestamp, map[string]telemetrics.MetricRecord{
+			"sw1": {LatencyMs: 2},
+		}))
+		require.NoError(t, store.SetLastUpdateTime(ctx, timestamp))
+	}
+	return store
+}
+
+func TestRollup_RecordsLastBucket(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	NewRollup(store, 2*time.Minute, testTiers, time.Minute, 0).rollupCompletedBuckets(ctx)
+
+	last, err := store.GetLastRollup(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NotZero(t, last)
+
+	rollups, err := store.GetRollupHistory(ctx, time.Minute, "sw1", "latency_ms", last, last)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RollupPoint{{Timestamp: last, Min: 2, Max: 2, Avg: 2, Count: 6}}, rollups)
+}
+
+func TestRollup_ResumesAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	NewRollup(store, 2*time.Minute, testTiers, time.Minute, 0).rollupCompletedBuckets(ctx)
+	last, err := store.GetLastRollup(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The bucket was rolled up from complete data, which has partly expired since
+	complete := telemetrics.RollupPoint{Timestamp: last, Min: 1, Max: 3, Avg: 2, Count: 12}
+	require.NoError(t, store.AddRollups(ctx, time.Minute, time.Hour, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": complete,
+	}))
+
+	// A restarted job must not roll it up again
+	NewRollup(store, 2*time.Minute, testTiers, time.Minute, 0).rollupCompletedBuckets(ctx)
+
+	rollups, err := store.GetRollupHistory(ctx, time.Minute, "sw1", "latency_ms", last, last)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RollupPoint{complete}, rollups)
+}
+
+func TestRollup_CatchesUpWithinRetention(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	// The job stopped long ago, only the buckets still holding raw data are rolled up
+	require.NoError(t, store.SetLastRollup(ctx, time.Minute, 60))
+	NewRollup(store, 2*time.Minute, testTiers, time.Minute, 0).rollupCompletedBuckets(ctx)
+
+	now := time.Now().Unix()
+	rollups, err := store.GetRollupHistory(ctx, time.Minute, "sw1", "latency_ms", 0, now)
+	require.NoError(t, err)
+	require.NotEmpty(t, rollups)
+	assert.GreaterOrEqual(t, rollups[0].Timestamp, now-180)
+
+	last, err := store.GetLastRollup(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, rollups[len(rollups)-1].Timestamp, last)
+}
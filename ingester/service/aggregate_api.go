@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/ingester/aggregate"
+)
+
+// AggregateHandler computes summary statistics of a metric over a time window per switch
+// If switch_id is omitted, every switch with data in the window is aggregated
+func (api *APIServer) AggregateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("AggregateHandler called")
+
+	metricName := r.URL.Query().Get("metric")
+	if metricName == "" {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing metric parameter")
+		return
+	}
+
+	from, to, err := parseTimeRange(r, api.config.Retention.Raw)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	resolution, err := api.selectResolution(r, from, to)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	switchID := r.URL.Query().Get("switch_id")
+	result, err := api.aggregate(ctx, resolution, switchID, metricName, from, to)
+	if err != nil {
+		api.logger.Error("Error aggregating metric", "switch_id", switchID, "metric", metricName, "error", err)
+		api.writeStoreError(w, r, err, "aggregation")
+		return
+	}
+
+	if switchID != "" && len(result) == 0 {
+		api.writeError(w, r, http.StatusNotFound, errCodeSwitchNotFound, "switch_id does not exist")
+		return
+	}
+
+	w.Header().Set(resolutionHeader, formatResolution(resolution))
+
+	api.writeJSON(w, r, 0, result)
+}
+
+// aggregate computes the statistics per switch at the given resolution, see getRollupSeries
+// An empty switchID aggregates every switch with data in [from, to]
+func (api *APIServer) aggregate(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string]aggregate.Aggregation, error) {
+	result := make(map[string]aggregate.Aggregation)
+
+	if resolution == 0 {
+		if switchID != "" {
+			points, err := api.dao.GetMetricHistory(ctx, switchID, metric, from, to)
+			if err != nil {
+				return nil, err
+			}
+			if len(points) > 0 {
+				result[switchID] = aggregate.Compute(points)
+			}
+			return result, nil
+		}
+
+		series, err := api.dao.GetAllMetricHistory(ctx, metric, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for id, points := range series {
+			result[id] = aggregate.Compute(points)
+		}
+		return result, nil
+	}
+
+	series, err := api.getRollupSeries(ctx, resolution, switchID, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for id, rollups := range series {
+		result[id] = aggregate.ComputeFromRollups(rollups)
+	}
+	return result, nil
+}
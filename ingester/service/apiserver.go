@@ -1,36 +1,84 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/yaron8/telemetry-infra/health"
+	"github.com/yaron8/telemetry-infra/ingester/auth"
 	"github.com/yaron8/telemetry-infra/ingester/config"
 	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/ingester/notify"
+	"github.com/yaron8/telemetry-infra/ingester/ratelimit"
+	"github.com/yaron8/telemetry-infra/instrument"
 	"github.com/yaron8/telemetry-infra/logi"
 )
 
 type APIServer struct {
 	config *config.Config
 	server *http.Server
-	dao    *dao.DAOMetrics
+	dao    dao.MetricStore
+	broker notify.Broker
+	etl    ETLStatus
+	auth   *auth.Authenticator // Nil if authentication is disabled
 	logger *slog.Logger
+
+	limiter   *ratelimit.Limiter            // Nil if rate limiting is disabled
+	expensive *ratelimit.ConcurrencyLimiter // Nil if expensive requests are unlimited
+
+	shuttingDown chan struct{} // Closed when Shutdown starts, ending the streams
+	shutdownOnce sync.Once     // Closes shuttingDown once, Shutdown may be called again
 }
 
-func NewAPIServer(config *config.Config, dao *dao.DAOMetrics) *APIServer {
+func NewAPIServer(config *config.Config, dao dao.MetricStore, broker notify.Broker, etl ETLStatus, authenticator *auth.Authenticator) *APIServer {
 
-	return &APIServer{
+	api := &APIServer{
 		config: config,
-		dao:    dao,
-		logger: logi.GetLogger(),
+		server: &http.Server{
+			Addr:         fmt.Sprintf(":%d", config.Port),
+			ReadTimeout:  60 * time.Second,
+			WriteTimeout: 60 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		dao:          dao,
+		broker:       broker,
+		etl:          etl,
+		auth:         authenticator,
+		logger:       logi.GetLogger(),
+		shuttingDown: make(chan struct{}),
+	}
+
+	if config.RateLimit.Rate > 0 {
+		api.limiter = ratelimit.NewLimiter(config.RateLimit.Rate, config.RateLimit.Burst)
 	}
+	if config.RateLimit.MaxConcurrent > 0 {
+		api.expensive = ratelimit.NewConcurrencyLimiter(config.RateLimit.MaxConcurrent, config.RateLimit.MaxWait)
+	}
+
+	// Set before Start, so a concurrent Shutdown never races with it
+	api.server.Handler = api.handler()
+
+	return api
 }
 
 // Start initializes and starts the HTTP server
 func (api *APIServer) Start() error {
 	api.logger.Info("Ingester APIServer starting", "port", api.config.Port)
 
+	if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		api.logger.Error("Server failed to start", "error", err, "port", api.config.Port)
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+
+	return nil
+}
+
+// handler returns the routes of the API wrapped in the middleware chain
+func (api *APIServer) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Health check endpoint
@@ -41,22 +89,44 @@ func (api *APIServer) Start() error {
 		}
 	})
 
-	// Telemetry endpoints
-	mux.HandleFunc("/telemetry/ListMetrics", api.ListMetricsHandler)
-	mux.HandleFunc("/telemetry/GetMetric", api.GetMetricHandler)
+	// Liveness and readiness probes
+	mux.Handle("/livez", health.LivenessHandler())
+	mux.Handle("/readyz", health.RedactedReadinessHandler(readinessCheckTimeout, api.isAuthenticated, api.readinessChecks()...))
 
-	api.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", api.config.Port),
-		Handler:      mux,
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+	// OpenAPI document of the telemetry endpoints
+	mux.HandleFunc("/openapi.json", api.OpenAPIHandler)
 
-	if err := api.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		api.logger.Error("Server failed to start", "error", err, "port", api.config.Port)
-		return fmt.Errorf("failed to start server: %w", err)
-	}
+	// Service metrics in the Prometheus text format
+	mux.Handle("/internal/metrics", api.requireScope(auth.ScopeAdmin, instrument.Handler().ServeHTTP))
+
+	// Prometheus exposition of the latest snapshot
+	mux.Handle("/metrics", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.PrometheusHandler)))
 
+	// Telemetry endpoints, served to clients granted the read scope if authentication is enabled
+	// Those reading whole snapshots or ranges are bounded by the concurrency limit
+	mux.Handle("/telemetry/ListMetrics", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.ListMetricsHandler)))
+	mux.Handle("/telemetry/GetMetric", api.requireScope(auth.ScopeRead, api.GetMetricHandler))
+	mux.Handle("/telemetry/GetMetrics", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.GetMetricsHandler)))
+	mux.Handle("/telemetry/GetMetricHistory", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.GetMetricHistoryHandler)))
+	mux.Handle("/telemetry/Aggregate", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.AggregateHandler)))
+	mux.Handle("/telemetry/TopK", api.requireScope(auth.ScopeRead, api.limitConcurrency(api.TopKHandler)))
+	mux.Handle("/telemetry/Watch", api.requireScope(auth.ScopeRead, api.WatchHandler))
+
+	return requestIDMiddleware(instrument.Middleware(api.rateLimitMiddleware(api.validationMiddleware(apiOperations(), mux))))
+}
+
+// Shutdown stops accepting connections, ends the Watch streams and waits for the in-flight
+// requests to complete until the context is done
+// Start returns once Shutdown is called
+// Calling it again, e.g. on a second signal, only waits for the requests again
+func (api *APIServer) Shutdown(ctx context.Context) error {
+	api.logger.Info("Ingester APIServer shutting down")
+	api.shutdownOnce.Do(func() {
+		close(api.shuttingDown)
+	})
+
+	if err := api.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/ingester/auth"
+	"github.com/yaron8/telemetry-infra/ingester/config"
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/ingester/notify"
+	"github.com/yaron8/telemetry-infra/logi"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "ingester-service-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, err := logi.NewLog(&logi.Config{LogDir: logDir}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = logi.Close()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+// etlStub reports the ETL as having just succeeded
+type etlStub struct{}
+
+func (etlStub) LastSuccess() time.Time {
+	return time.Now()
+}
+
+// newTestAPI creates an API server over the memory backend, with authentication if authenticator is not nil
+func newTestAPI(t *testing.T, authenticator *auth.Authenticator) (*APIServer, *dao.MemoryMetrics) {
+	t.Helper()
+	t.Setenv("STORAGE_BACKEND", config.StorageBackendMemory)
+
+	cfg := config.NewConfig()
+	store := dao.NewMemoryMetrics(cfg.Retention.Raw)
+	return NewAPIServer(cfg, store, notify.NewLocalBroker(), etlStub{}, authenticator), store
+}
+
+// newTestServer serves the API over the memory backend, with authentication if authenticator is not nil
+func newTestServer(t *testing.T, authenticator *auth.Authenticator) (*httptest.Server, *dao.MemoryMetrics) {
+	t.Helper()
+	api, store := newTestAPI(t, authenticator)
+
+	server := httptest.NewServer(api.server.Handler)
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+// commitTestSnapshot commits the records as the snapshot of the given timestamp
+func commitTestSnapshot(t *testing.T, store dao.MetricStore, timestamp int64, records map[string]telemetrics.MetricRecord) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, store.AddMetrics(ctx, timestamp, records))
+	require.NoError(t, store.SetLastUpdateTime(ctx, timestamp))
+}
+
+// get sends a GET request with the given headers, returning the response and its body
+func get(t *testing.T, url string, headers map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestAPIServer_NoSnapshot(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	resp, _ := get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=latency_ms", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, errCodeNoSnapshot, resp.Header.Get(errorCodeHeader))
+}
+
+func TestAPIServer_ListMetrics(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{
+		"sw1": {BandwidthMbps: 100, LatencyMs: 1.5},
+		"sw2": {BandwidthMbps: 300, LatencyMs: 0.5, PacketErrors: 2},
+	})
+
+	resp, body := get(t, server.URL+"/telemetry/ListMetrics?format=object", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	var switches map[string]telemetrics.MetricRecord
+	require.NoError(t, json.Unmarshal(body, &switches))
+	assert.Len(t, switches, 2)
+	assert.Equal(t, 300.0, switches["sw2"].BandwidthMbps)
+	assert.Equal(t, 2, switches["sw2"].PacketErrors)
+}
+
+func TestAPIServer_GetMetric(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{
+		"sw1": {BandwidthMbps: 100, LatencyMs: 1.5},
+	})
+
+	resp, body := get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=latency_ms", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.JSONEq(t, "1.5", string(body))
+
+	resp, _ = get(t, server.URL+"/telemetry/GetMetric?switch_id=sw9&metric=latency_ms", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, errCodeSwitchNotFound, resp.Header.Get(errorCodeHeader))
+
+	resp, _ = get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, errCodeInvalidParameter, resp.Header.Get(errorCodeHeader))
+
+	// Metric names are validated against the document
+	resp, body = get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=cpu", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, errCodeInvalidParameter, resp.Header.Get(errorCodeHeader))
+	assert.Contains(t, string(body), "invalid metric parameter")
+}
+
+func TestAPIServer_TopK(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{
+		"sw1": {BandwidthMbps: 100},
+		"sw2": {BandwidthMbps: 300},
+		"sw3": {BandwidthMbps: 200},
+	})
+
+	resp, body := get(t, server.URL+"/telemetry/TopK?metric=bandwidth_mbps&k=2", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	var ranking []telemetrics.RankedSwitch
+	require.NoError(t, json.Unmarshal(body, &ranking))
+	assert.Equal(t, []telemetrics.RankedSwitch{
+		{SwitchID: "sw2", Value: 300},
+		{SwitchID: "sw3", Value: 200},
+	}, ranking)
+}
+
+func TestAPIServer_GetMetricHistory(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	now := time.Now().Unix()
+	commitTestSnapshot(t, store, now-20, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 1}})
+	commitTestSnapshot(t, store, now-10, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 2}})
+	commitTestSnapshot(t, store, now, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 3}})
+
+	resp, body := get(t, server.URL+"/telemetry/GetMetricHistory?switch_id=sw1&metric=latency_ms&window=1m", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, resolutionRaw, resp.Header.Get(resolutionHeader))
+
+	var points []telemetrics.MetricPoint
+	require.NoError(t, json.Unmarshal(body, &points))
+	assert.Equal(t, []telemetrics.MetricPoint{
+		{Timestamp: now - 20, Value: 1},
+		{Timestamp: now - 10, Value: 2},
+		{Timestamp: now, Value: 3},
+	}, points)
+}
+
+// unreachableStore fails the reads of records like a store whose connection is lost
+type unreachableStore struct {
+	*dao.MemoryMetrics
+}
+
+func (unreachableStore) GetMetric(ctx context.Context, timestamp int64, switchID string, metric string) (interface{}, error) {
+	return nil, errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+}
+
+func TestAPIServer_StorageErrorIsNotSwitchNotFound(t *testing.T) {
+	store := unreachableStore{dao.NewMemoryMetrics(time.Minute)}
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{"sw1": {}})
+
+	api := NewAPIServer(config.NewConfig(), store, notify.NewLocalBroker(), etlStub{}, nil)
+	server := httptest.NewServer(api.server.Handler)
+	t.Cleanup(server.Close)
+
+	resp, _ := get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=latency_ms", nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, errCodeStorageError, resp.Header.Get(errorCodeHeader))
+}
+
+func TestAPIServer_ShutdownTwice(t *testing.T) {
+	api, _ := newTestAPI(t, nil)
+	api.server.Addr = "127.0.0.1:0"
+
+	started := make(chan error, 1)
+	go func() {
+		started <- api.Start()
+	}()
+
+	// Shutdown may run while Start is still setting up, and again on a second signal
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, api.Shutdown(ctx))
+	require.NoError(t, api.Shutdown(ctx))
+
+	select {
+	case <-api.shuttingDown:
+	default:
+		t.Fatal("Shutdown did not end the streams")
+	}
+
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return once the server was shut down")
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yaron8/telemetry-infra/ingester/auth"
+)
+
+// authChallenge is the WWW-Authenticate header of unauthenticated requests
+const authChallenge = `Bearer realm="telemetry"`
+
+type principalKey struct{}
+
+// principal returns the authenticated client of the request, false if authentication is disabled
+func principal(ctx context.Context) (auth.Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(auth.Principal)
+	return p, ok
+}
+
+// requireScope serves the route only to clients granted the scope
+// Requests without valid credentials are rejected with 401, and those lacking the scope with 403
+// Every request is served if authentication is disabled
+func (api *APIServer) requireScope(scope string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.auth == nil {
+			next(w, r)
+			return
+		}
+
+		p, err := api.auth.Authenticate(r)
+		if err != nil {
+			api.logger.Warn("Rejecting unauthenticated request", "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", authChallenge)
+			message := "invalid credentials"
+			if errors.Is(err, auth.ErrMissingCredentials) {
+				message = fmt.Sprintf("missing credentials: send an API key in the %s header or a bearer token", auth.APIKeyHeader)
+			}
+			api.writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized, message)
+			return
+		}
+
+		if !p.HasScope(scope) {
+			api.logger.Warn("Rejecting request lacking scope", "path", r.URL.Path, "client", p.Name, "scope", scope)
+			api.writeError(w, r, http.StatusForbidden, errCodeForbidden,
+				fmt.Sprintf("client %s lacks the %s scope", p.Name, scope))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// isAuthenticated reports whether the request carries valid credentials, always true if
+// authentication is disabled
+func (api *APIServer) isAuthenticated(r *http.Request) bool {
+	if api.auth == nil {
+		return true
+	}
+	_, err := api.auth.Authenticate(r)
+	return err == nil
+}
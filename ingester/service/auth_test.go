@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/health"
+	"github.com/yaron8/telemetry-infra/ingester/auth"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const (
+	testReadKey    = "read-key-0123456789"
+	testNoScopeKey = "no-scope-key-0123456789"
+	testAdminKey   = "admin-key-0123456789"
+)
+
+func TestRequireScope(t *testing.T) {
+	authenticator, err := auth.New(auth.KeysFile{
+		APIKeys: []auth.APIKey{
+			{Name: "reader", Key: testReadKey, Scopes: []string{auth.ScopeRead}},
+			{Name: "unprivileged", Key: testNoScopeKey},
+			{Name: "operator", Key: testAdminKey, Scopes: []string{auth.ScopeAdmin}},
+		},
+	})
+	require.NoError(t, err)
+
+	server, store := newTestServer(t, authenticator)
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{"sw1": {}})
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+		code   string
+	}{
+		{"missing credentials", "/telemetry/ListMetrics", "", http.StatusUnauthorized, errCodeUnauthorized},
+		{"unknown key", "/telemetry/ListMetrics", "unknown-key-0123456789", http.StatusUnauthorized, errCodeUnauthorized},
+		{"lacking read scope", "/telemetry/ListMetrics", testNoScopeKey, http.StatusForbidden, errCodeForbidden},
+		{"read scope", "/telemetry/ListMetrics", testReadKey, http.StatusOK, ""},
+		{"admin implies read", "/telemetry/ListMetrics", testAdminKey, http.StatusOK, ""},
+		{"lacking admin scope", "/internal/metrics", testReadKey, http.StatusForbidden, errCodeForbidden},
+		{"admin scope", "/internal/metrics", testAdminKey, http.StatusOK, ""},
+		{"probes are open", "/livez", "", http.StatusOK, ""},
+		{"document is open", "/openapi.json", "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.key != "" {
+				headers[auth.APIKeyHeader] = tt.key
+			}
+
+			resp, body := get(t, server.URL+tt.path, headers)
+			require.Equal(t, tt.status, resp.StatusCode, string(body))
+			assert.Equal(t, tt.code, resp.Header.Get(errorCodeHeader))
+
+			// Only unauthenticated requests are challenged
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, authChallenge, resp.Header.Get("WWW-Authenticate"))
+			} else {
+				assert.Empty(t, resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestReadyz_DetailsOnlyForAuthenticatedClients(t *testing.T) {
+	authenticator, err := auth.New(auth.KeysFile{
+		APIKeys: []auth.APIKey{{Name: "reader", Key: testReadKey, Scopes: []string{auth.ScopeRead}}},
+	})
+	require.NoError(t, err)
+
+	// No snapshot was committed, so the snapshot check fails
+	server, _ := newTestServer(t, authenticator)
+
+	readyz := func(headers map[string]string) map[string]map[string]interface{} {
+		resp, body := get(t, server.URL+"/readyz", headers)
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, string(body))
+
+		var report struct {
+			Status string                            `json:"status"`
+			Checks map[string]map[string]interface{} `json:"checks"`
+		}
+		require.NoError(t, json.Unmarshal(body, &report))
+		require.Equal(t, health.StatusFail, report.Status)
+		require.Equal(t, health.StatusFail, report.Checks["snapshot"]["status"])
+		return report.Checks
+	}
+
+	// The check names and statuses are open, the errors of the dependencies are not
+	for _, headers := range []map[string]string{nil, {auth.APIKeyHeader: "unknown-key-0123456789"}} {
+		for name, result := range readyz(headers) {
+			assert.Equal(t, map[string]interface{}{"status": result["status"]}, result, name)
+		}
+	}
+
+	checks := readyz(map[string]string{auth.APIKeyHeader: testReadKey})
+	assert.NotEmpty(t, checks["snapshot"]["error"])
+	assert.Contains(t, checks["snapshot"], "duration_ms")
+}
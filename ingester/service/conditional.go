@@ -0,0 +1,61 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotETag returns the entity tag of the responses read from the snapshot of the given timestamp
+// in the given variant, see responseVariant
+// A snapshot never changes once committed, but the tag is weak since the envelope metadata does
+func snapshotETag(timestamp int64, variant string) string {
+	return `W/"` + strconv.FormatInt(timestamp, 10) + "-" + variant + `"`
+}
+
+// responseVariant names the form of a response negotiated from the request, its format and
+// whether it is wrapped in the envelope, so that each form of a snapshot has its own tag
+func responseVariant(format string, envelope bool) string {
+	if envelope {
+		return format + "-envelope"
+	}
+	return format
+}
+
+// checkNotModified sets the ETag and Last-Modified headers of a response read from the snapshot
+// of the given timestamp in the given variant, and answers 304 if the client already has it
+// The variant may be negotiated from the Accept header, so caches must key on it too
+// If-None-Match takes precedence over If-Modified-Since, like in RFC 9110
+// Returns true if the 304 was written
+func (api *APIServer) checkNotModified(w http.ResponseWriter, r *http.Request, timestamp int64, variant string) bool {
+	etag := snapshotETag(timestamp, variant)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", time.Unix(timestamp, 0).UTC().Format(http.TimeFormat))
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else {
+		ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || timestamp > ifModifiedSince.Unix() {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether the If-None-Match header lists the tag, comparing weakly
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestConditional_ListMetricsTagsEachVariant(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	now := time.Now().Unix()
+	commitTestSnapshot(t, store, now, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 1.5}})
+	url := server.URL + "/telemetry/ListMetrics"
+
+	csv, _ := get(t, url, map[string]string{"Accept": contentTypeCSV})
+	require.Equal(t, http.StatusOK, csv.StatusCode)
+	assert.Equal(t, fmt.Sprintf(`W/"%d-csv"`, now), csv.Header.Get("ETag"))
+	assert.Equal(t, "Accept", csv.Header.Get("Vary"))
+
+	enveloped, _ := get(t, url, map[string]string{"Accept": contentTypeEnvelope})
+	require.Equal(t, http.StatusOK, enveloped.StatusCode)
+	assert.Equal(t, fmt.Sprintf(`W/"%d-json-envelope"`, now), enveloped.Header.Get("ETag"))
+
+	// The CSV tag doesn't validate the JSON form of the same snapshot
+	resp, body := get(t, url, map[string]string{"If-None-Match": csv.Header.Get("ETag")})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, body)
+	assert.Equal(t, fmt.Sprintf(`W/"%d-json"`, now), resp.Header.Get("ETag"))
+
+	resp, body = get(t, url, map[string]string{
+		"Accept":        contentTypeCSV,
+		"If-None-Match": csv.Header.Get("ETag"),
+	})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+}
+
+func TestConditional_GetMetricVariesOnAccept(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	now := time.Now().Unix()
+	commitTestSnapshot(t, store, now, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 1.5}})
+	url := server.URL + "/telemetry/GetMetric?switch_id=sw1&metric=latency_ms"
+
+	resp, _ := get(t, url, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	plain := resp.Header.Get("ETag")
+	assert.Equal(t, fmt.Sprintf(`W/"%d-json"`, now), plain)
+
+	// The envelope selected by the Accept header is another variant
+	resp, body := get(t, url, map[string]string{
+		"Accept":        contentTypeEnvelope,
+		"If-None-Match": plain,
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `"data":1.5`)
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	assert.Equal(t, fmt.Sprintf(`W/"%d-json-envelope"`, now), resp.Header.Get("ETag"))
+
+	resp, _ = get(t, url, map[string]string{"If-None-Match": plain})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
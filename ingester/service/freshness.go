@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/ingester/config"
+)
+
+const (
+	// dataAgeHeader reports the age in seconds of the snapshot a response was read from
+	dataAgeHeader = "X-Data-Age"
+	// staleWarning is the Warning header of a response served from a stale snapshot
+	staleWarning = `110 - "Response is Stale"`
+)
+
+// snapshotAge returns the age of the snapshot of the given timestamp
+func snapshotAge(timestamp int64) time.Duration {
+	return max(time.Since(time.Unix(timestamp, 0)), 0).Truncate(time.Second)
+}
+
+// checkFreshness reports the age of the snapshot a response is read from in the X-Data-Age header
+// and applies the freshness policy if it is the latest snapshot, which is stale once the ETL stops
+// A stale snapshot is served with a Warning header, or rejected with 503 by the reject policy
+// A snapshot past the raw retention has expired and is always rejected, since its switches are gone
+// Returns false if the request was rejected
+func (api *APIServer) checkFreshness(w http.ResponseWriter, r *http.Request, timestamp int64, latest bool) bool {
+	age := snapshotAge(timestamp)
+	w.Header().Set(dataAgeHeader, strconv.FormatInt(int64(age.Seconds()), 10))
+
+	if !latest || age <= api.config.Freshness.MaxAge {
+		return true
+	}
+
+	if api.config.Freshness.Policy == config.FreshnessPolicyReject || age > api.config.Retention.Raw {
+		api.logger.Warn("Rejecting request on stale snapshot", "snapshot", timestamp, "age", age)
+		w.Header().Set("Retry-After", strconv.Itoa(int(api.config.ETL.Interval.Seconds())))
+		api.writeError(w, r, http.StatusServiceUnavailable, errCodeDataStale,
+			fmt.Sprintf("latest snapshot is stale: %s old", age))
+		return false
+	}
+
+	w.Header().Set("Warning", staleWarning)
+	return true
+}
@@ -1,51 +1,53 @@
 package service
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
 func (api *APIServer) GetMetricHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	api.logger.Info("GetMetricHandler called")
 
 	switchID := r.URL.Query().Get("switch_id")
 	if switchID == "" {
-		http.Error(w, "Missing switch_id parameter", http.StatusBadRequest)
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing switch_id parameter")
 		return
 	}
 
 	metricName := r.URL.Query().Get("metric")
 	if metricName == "" {
-		http.Error(w, "Missing metric parameter", http.StatusBadRequest)
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing metric parameter")
 		return
 	}
 
-	val, err := api.dao.GetMetric(ctx, switchID, metricName)
+	at, err := parseUnixParam(r, "at", 0)
 	if err != nil {
-		api.logger.Error("Error getting metric", "switch_id", switchID, "metric", metricName, "error", err)
-		http.Error(w, err.Error(),
-			http.StatusNotFound)
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	timestamp, err := api.snapshotTime(ctx, at)
+	if err != nil {
+		api.logger.Error("Error retrieving snapshot time", "at", at, "error", err)
+		api.writeStoreError(w, r, err, "metric")
 		return
 	}
 
-	// Set content type to JSON
-	w.Header().Set("Content-Type", "application/json")
+	if !api.checkFreshness(w, r, timestamp, at <= 0) {
+		return
+	}
 
-	// Marshal the value to JSON (handles string, float, int, bool, etc.)
-	jsonData, err := json.Marshal(val)
+	val, err := api.dao.GetMetric(ctx, timestamp, switchID, metricName)
 	if err != nil {
-		api.logger.Error("Error encoding value to JSON", "switch_id", switchID, "metric", metricName, "error", err)
-		http.Error(w, fmt.Sprintf("Error encoding value to JSON: %v", err),
-			http.StatusInternalServerError)
+		api.logger.Error("Error getting metric", "switch_id", switchID, "metric", metricName, "error", err)
+		api.writeStoreError(w, r, err, "metric")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(jsonData); err != nil {
-		api.logger.Error("Error writing response", "error", err)
+	if api.checkNotModified(w, r, timestamp, responseVariant(formatJSON, wantsEnvelope(r))) {
+		return
 	}
+
+	api.writeJSON(w, r, timestamp, val)
 }
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func (api *APIServer) GetMetricHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("GetMetricHistoryHandler called")
+
+	switchID := r.URL.Query().Get("switch_id")
+	if switchID == "" {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing switch_id parameter")
+		return
+	}
+
+	metricName := r.URL.Query().Get("metric")
+	if metricName == "" {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing metric parameter")
+		return
+	}
+
+	from, to, err := parseTimeRange(r, api.config.Retention.Raw)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	resolution, err := api.selectResolution(r, from, to)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	points, err := api.getMetricHistory(ctx, resolution, switchID, metricName, from, to)
+	if err != nil {
+		api.logger.Error("Error getting metric history", "switch_id", switchID, "metric", metricName, "error", err)
+		api.writeStoreError(w, r, err, "metric history")
+		return
+	}
+
+	w.Header().Set(resolutionHeader, formatResolution(resolution))
+
+	api.writeJSON(w, r, 0, points)
+}
+
+// getMetricHistory retrieves the points of a metric for a switch at the given resolution
+// Rollup buckets are returned as points holding the bucket average, followed by the raw points
+// not rolled up yet, see getRollupSeries
+func (api *APIServer) getMetricHistory(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) ([]telemetrics.MetricPoint, error) {
+	if resolution == 0 {
+		return api.dao.GetMetricHistory(ctx, switchID, metric, from, to)
+	}
+
+	series, err := api.getRollupSeries(ctx, resolution, switchID, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := series[switchID]
+	points := make([]telemetrics.MetricPoint, len(rollups))
+	for i, rollup := range rollups {
+		points[i] = telemetrics.MetricPoint{
+			Timestamp: rollup.Timestamp,
+			Value:     rollup.Avg,
+		}
+	}
+
+	return points, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const (
+	// maxBatchSwitches bounds the number of switches of a single batch lookup
+	maxBatchSwitches = 1000
+	// maxBatchBodyBytes bounds the size of a batch lookup request body
+	maxBatchBodyBytes = 1 << 20
+)
+
+// batchRequest is the JSON body of a POST batch lookup
+type batchRequest struct {
+	SwitchIDs []string `json:"switch_ids"`
+	Metrics   []string `json:"metrics"`
+}
+
+// GetMetricsHandler looks up many metrics of many switches in a single call
+// The switches and metrics are read from repeated switch_id and metric query parameters,
+// or from a {"switch_ids": [...], "metrics": [...]} JSON body when POSTed
+// Every metric is returned if none is requested, and switches missing from the snapshot are omitted
+func (api *APIServer) GetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("GetMetricsHandler called")
+
+	var req batchRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.SwitchIDs = r.URL.Query()["switch_id"]
+		req.Metrics = r.URL.Query()["metric"]
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&req); err != nil {
+			api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		api.writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if len(req.SwitchIDs) == 0 {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing switch_id parameter")
+		return
+	}
+	if len(req.SwitchIDs) > maxBatchSwitches {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, fmt.Sprintf("Too many switches: at most %d per call", maxBatchSwitches))
+		return
+	}
+	if len(req.Metrics) == 0 {
+		req.Metrics = telemetrics.GetMetricNames()
+	}
+
+	at, err := parseUnixParam(r, "at", 0)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	timestamp, err := api.snapshotTime(ctx, at)
+	if err != nil {
+		api.logger.Error("Error retrieving snapshot time", "at", at, "error", err)
+		api.writeStoreError(w, r, err, "metrics")
+		return
+	}
+
+	if !api.checkFreshness(w, r, timestamp, at <= 0) {
+		return
+	}
+
+	values, err := api.dao.GetMetrics(ctx, timestamp, req.SwitchIDs, req.Metrics)
+	if err != nil {
+		api.logger.Error("Error retrieving metrics", "switches", len(req.SwitchIDs), "error", err)
+		api.writeStoreError(w, r, err, "metrics")
+		return
+	}
+
+	api.writeJSON(w, r, timestamp, values)
+}
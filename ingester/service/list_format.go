@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Output formats of ListMetrics
+const (
+	formatJSON   = "json"   // Array of single-switch objects
+	formatObject = "object" // Object keyed by switch ID
+	formatNDJSON = "ndjson" // One flat object per line
+	formatCSV    = "csv"    // One flat row per switch, with a header
+
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// listFormats lists the formats of ListMetrics, in the order they are documented
+var listFormats = []string{formatJSON, formatObject, formatNDJSON, formatCSV}
+
+// listFormatContentTypes maps each list format to its content type
+var listFormatContentTypes = map[string]string{
+	formatJSON:   contentTypeJSON,
+	formatObject: contentTypeJSON,
+	formatNDJSON: contentTypeNDJSON,
+	formatCSV:    contentTypeCSV,
+}
+
+// listMediaTypeFormats maps the media types accepted in the Accept header to list formats
+// The object form has no media type of its own and is only selected by format=object
+var listMediaTypeFormats = map[string]string{
+	contentTypeJSON:   formatJSON,
+	contentTypeNDJSON: formatNDJSON,
+	contentTypeCSV:    formatCSV,
+}
+
+// negotiateListFormat selects the output format of a listing
+// The format parameter takes precedence over the Accept header, and JSON is used if neither
+// selects a supported format
+func negotiateListFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := listFormatContentTypes[format]; !ok {
+			return "", fmt.Errorf("invalid format parameter: expected one of %s", strings.Join(listFormats, ", "))
+		}
+		return format, nil
+	}
+
+	// Pick the supported media type of the highest quality, the first one on ties
+	format, bestQuality := formatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		candidate, ok := listMediaTypeFormats[mediaType]
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if rawQuality, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(rawQuality, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			format, bestQuality = candidate, quality
+		}
+	}
+
+	return format, nil
+}
+
+// writeList streams the entries of a listing to w in the given format, one entry at a time,
+// so the response is never built in memory
+func writeList(w io.Writer, format string, query listQuery, entries []listEntry) error {
+	switch format {
+	case formatObject:
+		return writeObjectList(w, query, entries)
+	case formatNDJSON:
+		return writeNDJSONList(w, query, entries)
+	case formatCSV:
+		return writeCSVList(w, query, entries)
+	default:
+		return writeJSONList(w, query, entries)
+	}
+}
+
+// writeJSONList writes the entries as an array of single-switch objects
+func writeJSONList(w io.Writer, query listQuery, entries []listEntry) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(query.project(entry))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// writeObjectList writes the entries as a single object keyed by switch ID, in listing order
+func writeObjectList(w io.Writer, query listQuery, entries []listEntry) error {
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		var line bytes.Buffer
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, err := json.Marshal(entry.switchID)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(query.projectValue(entry))
+		if err != nil {
+			return err
+		}
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(value)
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// writeNDJSONList writes every entry as a flat object with a switch_id field on its own line
+func writeNDJSONList(w io.Writer, query listQuery, entries []listEntry) error {
+	columns := query.columns()
+	for _, entry := range entries {
+		var line bytes.Buffer
+		switchID, err := json.Marshal(entry.switchID)
+		if err != nil {
+			return err
+		}
+		line.WriteString(`{"switch_id":`)
+		line.Write(switchID)
+		for _, column := range columns {
+			value, _ := entry.record.GetMetricValue(column)
+			fmt.Fprintf(&line, ",%q:%s", column, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		line.WriteString("}\n")
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSVList writes a header of switch_id and the metrics, then a row per entry
+func writeCSVList(w io.Writer, query listQuery, entries []listEntry) error {
+	columns := query.columns()
+	csvWriter := csv.NewWriter(w)
+
+	if err := csvWriter.Write(append([]string{"switch_id"}, columns...)); err != nil {
+		return err
+	}
+
+	row := make([]string, len(columns)+1)
+	for _, entry := range entries {
+		row[0] = entry.switchID
+		for i, column := range columns {
+			value, _ := entry.record.GetMetricValue(column)
+			row[i+1] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
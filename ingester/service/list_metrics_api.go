@@ -1,33 +1,101 @@
 package service
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
+// ListMetricsHandler lists the switches of the latest snapshot, or the one selected by at
+// The switches can be filtered, sorted, projected to a subset of fields and paginated,
+// see parseListQuery
+// The output format is negotiated from the Accept header or the format parameter,
+// see negotiateListFormat
+// Conditional requests are answered with 304 if the client has the snapshot, see checkNotModified
 func (api *APIServer) ListMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	api.logger.Info("ListMetricsHandler called")
 
-	allKeysAndMetrics, err := api.dao.GetAll(ctx)
+	query, err := parseListQuery(r)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	format, err := negotiateListFormat(r)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	at, err := parseUnixParam(r, "at", 0)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	// Every page of a listing reads the snapshot of its first page
+	var timestamp int64
+	if query.cursor != nil {
+		timestamp = query.cursor.Timestamp
+	} else {
+		timestamp, err = api.snapshotTime(ctx, at)
+	}
+	if err != nil {
+		api.logger.Error("Error retrieving snapshot time", "at", at, "error", err)
+		api.writeStoreError(w, r, err, "metrics")
+		return
+	}
+
+	if !api.checkFreshness(w, r, timestamp, query.cursor == nil && at <= 0) {
+		return
+	}
+
+	// Only the JSON formats can be wrapped in the envelope
+	envelope := wantsEnvelope(r) && (format == formatJSON || format == formatObject)
+
+	// A snapshot never changes, so a client having it is answered before it is read
+	if api.checkNotModified(w, r, timestamp, responseVariant(format, envelope)) {
+		return
+	}
+
+	allKeysAndMetrics, err := api.dao.GetAll(ctx, timestamp, query.filter)
 	if err != nil {
 		api.logger.Error("Error retrieving metrics", "error", err)
-		http.Error(w, fmt.Sprintf("Error retrieving metrics: %v", err),
-			http.StatusInternalServerError)
+		api.writeStoreError(w, r, err, "metrics")
 		return
 	}
 
-	// Set content type and status code before encoding
-	w.Header().Set("Content-Type", "application/json")
+	entries := make([]listEntry, 0, len(allKeysAndMetrics))
+	for _, keyAndMetric := range allKeysAndMetrics {
+		for switchID, record := range keyAndMetric {
+			entries = append(entries, listEntry{switchID: switchID, record: record})
+		}
+	}
+
+	page, next := query.page(timestamp, entries)
+
+	contentType := listFormatContentTypes[format]
+	if envelope {
+		contentType = contentTypeEnvelope
+	}
+
+	// Set content type and status code before streaming
+	w.Header().Set("Content-Type", contentType)
+	if next != nil {
+		w.Header().Set(nextCursorHeader, encodeListCursor(next))
+	}
 	w.WriteHeader(http.StatusOK)
 
-	// Use encoder to stream JSON directly to response writer
-	// This avoids allocating the entire JSON in memory
-	if err := json.NewEncoder(w).Encode(allKeysAndMetrics); err != nil {
+	// Stream the entries one at a time directly to the response writer
+	// This avoids allocating the entire response in memory
+	if envelope {
+		err = api.writeEnvelopeList(w, r, timestamp, format, query, page)
+	} else {
+		err = writeList(w, format, query, page)
+	}
+	if err != nil {
 		// Can't send error response after WriteHeader, just log it
-		api.logger.Error("Error encoding metrics to JSON", "error", err)
+		api.logger.Error("Error writing metrics", "format", format, "error", err)
 		return
 	}
 }
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const (
+	sortBySwitchID = "switch_id"
+	// nextCursorHeader holds the cursor of the next page, absent on the last page
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// listQuery holds the filtering, projection, sorting and pagination parameters of ListMetrics
+type listQuery struct {
+	filter dao.SwitchFilter
+	fields []string // Metrics to include, all of the record if empty
+	sort   string   // sortBySwitchID or a metric name
+	order  string
+	limit  int // Page size, unlimited if 0
+	cursor *listCursor
+}
+
+// listCursor is the position after the last entry of a page
+// It pins the snapshot, so every page of a listing reads the same snapshot
+type listCursor struct {
+	Timestamp int64   `json:"ts"`
+	Sort      string  `json:"sort"`
+	Order     string  `json:"order"`
+	SwitchID  string  `json:"switch_id"`
+	Value     float64 `json:"value,omitempty"`
+}
+
+// listEntry is a single switch of a listing
+type listEntry struct {
+	switchID string
+	record   telemetrics.MetricRecord
+}
+
+// parseListQuery parses the ListMetrics query parameters:
+// switch_id (glob pattern), prefix, fields (comma-separated metrics), sort (switch_id or a metric),
+// order (asc or desc), limit and cursor
+func parseListQuery(r *http.Request) (listQuery, error) {
+	query := r.URL.Query()
+
+	q := listQuery{
+		filter: dao.SwitchFilter{
+			Prefix:  query.Get("prefix"),
+			Pattern: query.Get("switch_id"),
+		},
+		sort: query.Get("sort"),
+	}
+
+	if err := q.filter.Validate(); err != nil {
+		return listQuery{}, fmt.Errorf("invalid switch_id parameter: %w", err)
+	}
+
+	if rawFields := query.Get("fields"); rawFields != "" {
+		for _, field := range strings.Split(rawFields, ",") {
+			field = strings.TrimSpace(field)
+			if !slices.Contains(telemetrics.GetMetricNames(), field) {
+				return listQuery{}, fmt.Errorf("invalid fields parameter: unknown metric %q", field)
+			}
+			q.fields = append(q.fields, field)
+		}
+	}
+
+	if q.sort == "" {
+		q.sort = sortBySwitchID
+	}
+	if q.sort != sortBySwitchID && !slices.Contains(telemetrics.GetMetricNames(), q.sort) {
+		return listQuery{}, fmt.Errorf("invalid sort parameter: expected %s or a metric name", sortBySwitchID)
+	}
+
+	order, err := parseOrderParam(r, orderAsc)
+	if err != nil {
+		return listQuery{}, err
+	}
+	q.order = order
+
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit <= 0 {
+			return listQuery{}, fmt.Errorf("invalid limit parameter: expected positive integer")
+		}
+		q.limit = limit
+	}
+
+	if rawCursor := query.Get("cursor"); rawCursor != "" {
+		cursor, err := decodeListCursor(rawCursor)
+		if err != nil || cursor.Sort != q.sort || cursor.Order != q.order {
+			return listQuery{}, fmt.Errorf("invalid cursor parameter")
+		}
+		q.cursor = cursor
+	}
+
+	return q, nil
+}
+
+// page sorts the entries and returns the page following the cursor
+// The cursor of the next page is nil if this is the last page
+func (q listQuery) page(timestamp int64, entries []listEntry) ([]listEntry, *listCursor) {
+	sort.Slice(entries, func(i, j int) bool {
+		return q.compare(entries[i].switchID, q.sortValue(entries[i]), entries[j].switchID, q.sortValue(entries[j])) < 0
+	})
+
+	if q.cursor != nil {
+		start := sort.Search(len(entries), func(i int) bool {
+			return q.compare(entries[i].switchID, q.sortValue(entries[i]), q.cursor.SwitchID, q.cursor.Value) > 0
+		})
+		entries = entries[start:]
+	}
+
+	if q.limit == 0 || len(entries) <= q.limit {
+		return entries, nil
+	}
+
+	entries = entries[:q.limit]
+	last := entries[len(entries)-1]
+	return entries, &listCursor{
+		Timestamp: timestamp,
+		Sort:      q.sort,
+		Order:     q.order,
+		SwitchID:  last.switchID,
+		Value:     q.sortValue(last),
+	}
+}
+
+// project returns the entry as a single-entry map, keeping only the requested fields
+func (q listQuery) project(entry listEntry) interface{} {
+	return map[string]interface{}{
+		entry.switchID: q.projectValue(entry),
+	}
+}
+
+// projectValue returns the record of the entry, keeping only the requested fields
+func (q listQuery) projectValue(entry listEntry) interface{} {
+	if len(q.fields) == 0 {
+		return entry.record
+	}
+
+	values := make(map[string]float64, len(q.fields))
+	for _, field := range q.fields {
+		values[field], _ = entry.record.GetMetricValue(field)
+	}
+	return values
+}
+
+// columns returns the metrics of a flat row: the requested fields, or every metric
+func (q listQuery) columns() []string {
+	if len(q.fields) == 0 {
+		return telemetrics.GetMetricNames()
+	}
+	return q.fields
+}
+
+func (q listQuery) sortValue(entry listEntry) float64 {
+	value, _ := entry.record.GetMetricValue(q.sort)
+	return value
+}
+
+// compare orders two entries by the sort value, then by switch ID, in the requested order
+func (q listQuery) compare(switchA string, valueA float64, switchB string, valueB float64) int {
+	result := 0
+	switch {
+	case q.sort != sortBySwitchID && valueA < valueB:
+		result = -1
+	case q.sort != sortBySwitchID && valueA > valueB:
+		result = 1
+	default:
+		result = strings.Compare(switchA, switchB)
+	}
+
+	if q.order == orderDesc {
+		return -result
+	}
+	return result
+}
+
+func encodeListCursor(cursor *listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(raw string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
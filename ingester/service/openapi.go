@@ -0,0 +1,438 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/yaron8/telemetry-infra/ingester/auth"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const openAPIVersion = "3.0.3"
+
+// Kinds of query parameters, each with its own OpenAPI schema and validation
+const (
+	paramString     = "string"
+	paramInteger    = "integer"
+	paramTime       = "time"        // Unix timestamp in seconds or RFC3339 time
+	paramStringList = "string_list" // Comma-separated values
+)
+
+// apiParam describes a query parameter of an operation
+// It is the single source of both the OpenAPI document and the request validation
+type apiParam struct {
+	name        string
+	description string
+	kind        string
+	required    bool
+	enum        []string // Allowed values, of each item for a list
+	minimum     *int     // Lower bound of an integer
+}
+
+// apiOperation describes a GET operation served under path
+type apiOperation struct {
+	path        string
+	operationID string
+	summary     string
+	params      []apiParam
+	responses   map[int]openAPIResponse
+	scope       string // Scope required if authentication is enabled, empty for an open operation
+}
+
+// openAPISchema is a subset of the OpenAPI schema object
+type openAPISchema struct {
+	Ref                  string                   `json:"$ref,omitempty"`
+	Type                 string                   `json:"type,omitempty"`
+	Format               string                   `json:"format,omitempty"`
+	Enum                 []string                 `json:"enum,omitempty"`
+	Minimum              *int                     `json:"minimum,omitempty"`
+	Items                *openAPISchema           `json:"items,omitempty"`
+	OneOf                []openAPISchema          `json:"oneOf,omitempty"`
+	Properties           map[string]openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema           `json:"additionalProperties,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Style       string        `json:"style,omitempty"`
+	Explode     *bool         `json:"explode,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPIHeader struct {
+	Description string        `json:"description,omitempty"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIPathItem struct {
+	Get *openAPIOperation `json:"get,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+// openAPIDocument is the root of the OpenAPI document served on /openapi.json
+type openAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+// textResponse is a plain text response
+func textResponse(description string) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content: map[string]openAPIMediaType{
+			"text/plain": {Schema: openAPISchema{Type: "string"}},
+		},
+	}
+}
+
+// errorResponse is a failed response, in plain text or as an error in the envelope
+func errorResponse(description string) openAPIResponse {
+	response := textResponse(description)
+	response.Content[contentTypeEnvelope] = openAPIMediaType{
+		Schema: openAPISchema{Ref: "#/components/schemas/ErrorEnvelope"},
+	}
+	response.Headers = map[string]openAPIHeader{
+		errorCodeHeader: {
+			Description: "Error code of the failure",
+			Schema:      openAPISchema{Ref: "#/components/schemas/ErrorCode"},
+		},
+	}
+	return response
+}
+
+// retryableResponse is a failed response telling the client when to retry in the Retry-After header
+func retryableResponse(description string) openAPIResponse {
+	response := errorResponse(description)
+	response.Headers["Retry-After"] = openAPIHeader{
+		Description: "Seconds to wait before retrying",
+		Schema:      openAPISchema{Type: "integer"},
+	}
+	return response
+}
+
+// snapshotHeaders are the validators of a response read from a single snapshot
+func snapshotHeaders() map[string]openAPIHeader {
+	return map[string]openAPIHeader{
+		"ETag": {
+			Description: "Weak entity tag of the snapshot in the negotiated format",
+			Schema:      openAPISchema{Type: "string"},
+		},
+		"Vary": {
+			Description: "Accept, the format and the envelope may be negotiated from it",
+			Schema:      openAPISchema{Type: "string"},
+		},
+		"Last-Modified": {
+			Description: "Commit time of the snapshot",
+			Schema:      openAPISchema{Type: "string"},
+		},
+	}
+}
+
+// jsonResponse is a JSON response of the given schema, bare or as the data of the envelope
+func jsonResponse(description string, schema openAPISchema) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content: map[string]openAPIMediaType{
+			contentTypeJSON: {Schema: schema},
+			contentTypeEnvelope: {Schema: openAPISchema{
+				Type: "object",
+				Properties: map[string]openAPISchema{
+					"version": {Type: "integer"},
+					"data":    schema,
+					"meta":    {Ref: "#/components/schemas/ResponseMeta"},
+				},
+			}},
+		},
+	}
+}
+
+// apiOperations lists the documented and validated operations
+// The metric names are taken from telemetrics, so new metrics are documented without changes here
+func apiOperations() []apiOperation {
+	metricNames := telemetrics.GetMetricNames()
+	minimumLimit := 1
+
+	atParam := apiParam{
+		name:        "at",
+		description: "Read the latest snapshot committed at or before this time instead of the latest snapshot",
+		kind:        paramTime,
+	}
+
+	envelopeParam := apiParam{
+		name:        "envelope",
+		description: "Wrap the response in the versioned envelope, like accepting " + contentTypeEnvelope,
+		kind:        paramString,
+		enum:        []string{envelopeParamValue},
+	}
+
+	listResponse := jsonResponse("Switches of the snapshot, each keyed by its switch ID", openAPISchema{
+		Type: "array",
+		Items: &openAPISchema{
+			Type: "object",
+			AdditionalProperties: &openAPISchema{
+				Ref: "#/components/schemas/MetricRecord",
+			},
+		},
+	})
+	// The other formats are flat rows of switch_id and the metrics
+	listResponse.Content[contentTypeNDJSON] = openAPIMediaType{Schema: openAPISchema{Type: "string"}}
+	listResponse.Content[contentTypeCSV] = openAPIMediaType{Schema: openAPISchema{Type: "string"}}
+	listResponse.Headers = snapshotHeaders()
+	listResponse.Headers[nextCursorHeader] = openAPIHeader{
+		Description: "Cursor of the next page, absent on the last page",
+		Schema:      openAPISchema{Type: "string"},
+	}
+
+	metricResponse := jsonResponse("Value of the metric", openAPISchema{Type: "number"})
+	metricResponse.Headers = snapshotHeaders()
+
+	// Conditional requests send back the ETag in If-None-Match or the Last-Modified in If-Modified-Since
+	notModifiedResponse := openAPIResponse{
+		Description: "The client already has the snapshot, sent on a conditional request",
+		Headers:     snapshotHeaders(),
+	}
+
+	return []apiOperation{
+		{
+			path:        "/health",
+			operationID: "health",
+			summary:     "Liveness check of the ingester",
+			responses: map[int]openAPIResponse{
+				http.StatusOK: textResponse("The ingester is up, the body is OK"),
+			},
+		},
+		{
+			path:        "/telemetry/ListMetrics",
+			operationID: "listMetrics",
+			scope:       auth.ScopeRead,
+			summary:     "List the switches of a snapshot, filtered, sorted, projected and paginated",
+			params: []apiParam{
+				{name: "switch_id", description: "Glob pattern the switch IDs must match", kind: paramString},
+				{name: "prefix", description: "Prefix the switch IDs must start with", kind: paramString},
+				{name: "fields", description: "Metrics to include, all metrics if absent", kind: paramStringList, enum: metricNames},
+				{name: "sort", description: "Sort by switch ID or by a metric", kind: paramString, enum: append([]string{sortBySwitchID}, metricNames...)},
+				{name: "order", description: "Sort order", kind: paramString, enum: []string{orderAsc, orderDesc}},
+				{name: "limit", description: "Page size, unlimited if absent", kind: paramInteger, minimum: &minimumLimit},
+				{name: "cursor", description: "Cursor of the page to read, taken from the " + nextCursorHeader + " header", kind: paramString},
+				{name: "format", description: "Output format, taking precedence over the Accept header: an array of single-switch objects, an object keyed by switch ID, NDJSON or CSV rows", kind: paramString, enum: listFormats},
+				atParam,
+				envelopeParam,
+			},
+			responses: map[int]openAPIResponse{
+				http.StatusOK:                  listResponse,
+				http.StatusNotModified:         notModifiedResponse,
+				http.StatusBadRequest:          errorResponse("Invalid parameter"),
+				http.StatusUnauthorized:        errorResponse("Missing or invalid credentials"),
+				http.StatusForbidden:           errorResponse("The client lacks the read scope"),
+				http.StatusNotFound:            errorResponse("No snapshot exists"),
+				http.StatusTooManyRequests:     retryableResponse("The client exceeded its rate limit"),
+				http.StatusInternalServerError: errorResponse("Storage error"),
+				http.StatusServiceUnavailable:  retryableResponse("Too many expensive requests in flight, or the latest snapshot is stale"),
+			},
+		},
+		{
+			path:        "/telemetry/GetMetric",
+			operationID: "getMetric",
+			scope:       auth.ScopeRead,
+			summary:     "Get the value of a metric of a switch in a snapshot",
+			params: []apiParam{
+				{name: "switch_id", description: "ID of the switch", kind: paramString, required: true},
+				{name: "metric", description: "Name of the metric", kind: paramString, required: true, enum: metricNames},
+				atParam,
+				envelopeParam,
+			},
+			responses: map[int]openAPIResponse{
+				http.StatusOK:                  metricResponse,
+				http.StatusNotModified:         notModifiedResponse,
+				http.StatusBadRequest:          errorResponse("Missing or invalid parameter"),
+				http.StatusUnauthorized:        errorResponse("Missing or invalid credentials"),
+				http.StatusForbidden:           errorResponse("The client lacks the read scope"),
+				http.StatusNotFound:            errorResponse("The snapshot or switch does not exist"),
+				http.StatusTooManyRequests:     retryableResponse("The client exceeded its rate limit"),
+				http.StatusInternalServerError: errorResponse("Storage error"),
+				http.StatusServiceUnavailable:  retryableResponse("The latest snapshot is stale"),
+			},
+		},
+	}
+}
+
+// schema returns the OpenAPI schema of the parameter
+func (p apiParam) schema() openAPISchema {
+	switch p.kind {
+	case paramInteger:
+		return openAPISchema{Type: "integer", Minimum: p.minimum}
+	case paramTime:
+		return openAPISchema{OneOf: []openAPISchema{
+			{Type: "integer", Format: "int64"},
+			{Type: "string", Format: "date-time"},
+		}}
+	case paramStringList:
+		return openAPISchema{Type: "array", Items: &openAPISchema{Type: "string", Enum: p.enum}}
+	default:
+		return openAPISchema{Type: "string", Enum: p.enum}
+	}
+}
+
+// newOpenAPIDocument builds the OpenAPI document of the given operations
+func newOpenAPIDocument(operations []apiOperation) openAPIDocument {
+	recordProperties := map[string]openAPISchema{}
+	for _, metric := range telemetrics.GetMetricNames() {
+		recordProperties[metric] = openAPISchema{Type: "number"}
+	}
+
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "Telemetry Ingester API",
+			Version: "1.0.0",
+		},
+		Paths: map[string]openAPIPathItem{},
+		Components: openAPIComponents{
+			Schemas: map[string]openAPISchema{
+				"MetricRecord": {Type: "object", Properties: recordProperties},
+				"ResponseMeta": {Type: "object", Properties: map[string]openAPISchema{
+					"snapshot_ts":      {Type: "integer", Format: "int64"},
+					"data_age_seconds": {Type: "integer", Format: "int64"},
+					"source":           {Type: "string"},
+					"request_id":       {Type: "string"},
+				}},
+				"ErrorCode": {Type: "string", Enum: []string{
+					errCodeInvalidParameter,
+					errCodeMethodNotAllowed,
+					errCodeNoSnapshot,
+					errCodeSwitchNotFound,
+					errCodeMetricUnknown,
+					errCodeStorageError,
+					errCodeDataStale,
+					errCodeUnauthorized,
+					errCodeForbidden,
+					errCodeRateLimited,
+					errCodeOverloaded,
+				}},
+				"ErrorEnvelope": {Type: "object", Properties: map[string]openAPISchema{
+					"version": {Type: "integer"},
+					"error": {Type: "object", Properties: map[string]openAPISchema{
+						"code":    {Ref: "#/components/schemas/ErrorCode"},
+						"message": {Type: "string"},
+					}},
+					"meta": {Ref: "#/components/schemas/ResponseMeta"},
+				}},
+			},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"apiKey": {
+					Type:        "apiKey",
+					Description: "Static API key, also accepted as a bearer token",
+					Name:        auth.APIKeyHeader,
+					In:          "header",
+				},
+				"bearerToken": {
+					Type:         "http",
+					Description:  "HMAC-SHA256 signed token with the space-separated scopes in the scope claim",
+					Scheme:       "bearer",
+					BearerFormat: "JWT",
+				},
+			},
+		},
+	}
+
+	for _, operation := range operations {
+		op := &openAPIOperation{
+			OperationID: operation.operationID,
+			Summary:     operation.summary,
+			Responses:   map[string]openAPIResponse{},
+		}
+
+		for _, param := range operation.params {
+			parameter := openAPIParameter{
+				Name:        param.name,
+				In:          "query",
+				Description: param.description,
+				Required:    param.required,
+				Schema:      param.schema(),
+			}
+			// Lists are sent as a single comma-separated value
+			if param.kind == paramStringList {
+				explode := false
+				parameter.Style = "form"
+				parameter.Explode = &explode
+			}
+			op.Parameters = append(op.Parameters, parameter)
+		}
+
+		codes := make([]int, 0, len(operation.responses))
+		for code := range operation.responses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			op.Responses[strconv.Itoa(code)] = operation.responses[code]
+		}
+
+		// Scopes are not OAuth2 ones, so they are listed as roles of the schemes
+		if operation.scope != "" {
+			op.Security = []map[string][]string{
+				{"apiKey": {operation.scope}},
+				{"bearerToken": {operation.scope}},
+			}
+		}
+
+		doc.Paths[operation.path] = openAPIPathItem{Get: op}
+	}
+
+	return doc
+}
+
+// OpenAPIHandler serves the OpenAPI document of the ingester API
+func (api *APIServer) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	api.logger.Info("OpenAPIHandler called")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(newOpenAPIDocument(apiOperations())); err != nil {
+		api.logger.Error("Error encoding OpenAPI document to JSON", "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// parseTimeRange resolves the [from, to] range of a request in unix seconds
+// A window parameter (e.g. "10m") selects the range ending now, otherwise the optional
+// from/to parameters are used, defaulting to the defaultWindow ending now
+func parseTimeRange(r *http.Request, defaultWindow time.Duration) (int64, int64, error) {
+	now := time.Now().Unix()
+
+	if rawWindow := r.URL.Query().Get("window"); rawWindow != "" {
+		window, err := time.ParseDuration(rawWindow)
+		if err != nil || window <= 0 {
+			return 0, 0, fmt.Errorf("invalid window parameter: expected positive duration")
+		}
+		return now - int64(window.Seconds()), now, nil
+	}
+
+	from, err := parseUnixParam(r, "from", now-int64(defaultWindow.Seconds()))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to, err := parseUnixParam(r, "to", now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if from > to {
+		return 0, 0, fmt.Errorf("from must not be after to")
+	}
+
+	return from, to, nil
+}
+
+// parseUnixParam parses an optional query parameter holding a unix timestamp in seconds
+// or an RFC3339 time
+// Returns defaultValue if the parameter is absent
+func parseUnixParam(r *http.Request, name string, defaultValue int64) (int64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	if value, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return value, nil
+	}
+
+	if value, err := time.Parse(time.RFC3339, raw); err == nil {
+		return value.Unix(), nil
+	}
+
+	return 0, fmt.Errorf("invalid %s parameter: expected unix timestamp or RFC3339 time", name)
+}
+
+// parseOrderParam parses the optional order parameter, either asc or desc
+// Returns defaultOrder if the parameter is absent
+func parseOrderParam(r *http.Request, defaultOrder string) (string, error) {
+	order := r.URL.Query().Get("order")
+	if order == "" {
+		return defaultOrder, nil
+	}
+	if order != orderAsc && order != orderDesc {
+		return "", fmt.Errorf("invalid order parameter: expected %s or %s", orderAsc, orderDesc)
+	}
+	return order, nil
+}
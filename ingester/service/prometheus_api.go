@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/promtext"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// metricPrefix namespaces the switch metrics in the Prometheus exposition
+const metricPrefix = "switch_"
+
+// metricHelp describes each switch metric in the Prometheus exposition
+var metricHelp = map[string]string{
+	"bandwidth_mbps": "Bandwidth of the switch in megabits per second.",
+	"latency_ms":     "Latency of the switch in milliseconds.",
+	"packet_errors":  "Packet errors reported by the switch in the snapshot.",
+}
+
+// PrometheusHandler renders the latest snapshot in the Prometheus text exposition format,
+// one gauge family per metric labeled by switch_id
+// Samples carry the snapshot timestamp, so Prometheus stores them at the time they were collected
+func (api *APIServer) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("PrometheusHandler called")
+
+	timestamp, err := api.dao.GetLastUpdateTime(ctx)
+	if errors.Is(err, dao.ErrSnapshotNotFound) {
+		api.writeError(w, r, http.StatusServiceUnavailable, errCodeNoSnapshot, err.Error())
+		return
+	}
+	if err != nil {
+		api.logger.Error("Error retrieving snapshot time", "error", err)
+		api.writeStoreError(w, r, err, "metrics")
+		return
+	}
+
+	if !api.checkFreshness(w, r, timestamp, true) {
+		return
+	}
+
+	allKeysAndMetrics, err := api.dao.GetAll(ctx, timestamp, dao.SwitchFilter{})
+	if err != nil {
+		api.logger.Error("Error retrieving metrics", "error", err)
+		api.writeStoreError(w, r, err, "metrics")
+		return
+	}
+
+	// Order the samples by switch so consecutive scrapes render identically
+	switchIDs := make([]string, 0, len(allKeysAndMetrics))
+	records := make(map[string]telemetrics.MetricRecord, len(allKeysAndMetrics))
+	for _, keyAndMetric := range allKeysAndMetrics {
+		for switchID, record := range keyAndMetric {
+			switchIDs = append(switchIDs, switchID)
+			records[switchID] = record
+		}
+	}
+	sort.Strings(switchIDs)
+
+	w.Header().Set("Content-Type", promtext.ContentType)
+	w.WriteHeader(http.StatusOK)
+
+	timestampMs := timestamp * 1000
+	pw := promtext.NewWriter(w)
+	for _, metric := range telemetrics.GetMetricNames() {
+		name := metricPrefix + metric
+		pw.Family(name, metricHelp[metric], promtext.TypeGauge)
+		for _, switchID := range switchIDs {
+			value, _ := records[switchID].GetMetricValue(metric)
+			pw.Sample(name, []promtext.Label{{Name: "switch_id", Value: switchID}}, value, timestampMs)
+		}
+	}
+
+	pw.Family("switch_snapshot_timestamp_seconds", "Unix time the exposed snapshot was collected at.", promtext.TypeGauge)
+	pw.Sample("switch_snapshot_timestamp_seconds", nil, float64(timestamp), 0)
+
+	if err := pw.Flush(); err != nil {
+		// Can't send error response after WriteHeader, just log it
+		api.logger.Error("Error writing metrics exposition", "error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/instrument"
+)
+
+var (
+	rejectedRequests = instrument.NewCounterVec("ingester_requests_rejected_total",
+		"Requests rejected by the rate limit or shed under load, by reason.", "reason")
+	expensiveInFlight = instrument.NewGaugeVec("ingester_expensive_requests_in_flight",
+		"Expensive requests currently holding a concurrency slot.")
+)
+
+// unlimitedPaths are never rate limited, so probes from a single orchestrator address always get through
+var unlimitedPaths = map[string]bool{
+	"/health": true,
+	"/livez":  true,
+	"/readyz": true,
+}
+
+// clientKey identifies the client of a request for the rate limit: the authenticated client,
+// or the remote IP if authentication is disabled or the credentials are invalid
+func (api *APIServer) clientKey(r *http.Request) string {
+	if api.auth != nil {
+		if p, err := api.auth.Authenticate(r); err == nil {
+			return "client:" + p.Name
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware rejects the requests of clients exceeding their rate with 429
+// Every request is passed through if rate limiting is disabled
+func (api *APIServer) rateLimitMiddleware(next http.Handler) http.Handler {
+	if api.limiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := api.clientKey(r)
+		if ok, retryAfter := api.limiter.Allow(client); !ok {
+			api.logger.Warn("Rejecting request over the rate limit", "path", r.URL.Path, "client", client)
+			rejectedRequests.Inc("rate_limit")
+			setRetryAfter(w, retryAfter)
+			api.writeError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitConcurrency bounds the number of requests served at once by an expensive route, so a burst
+// of full scans can't saturate the storage
+// A request waits briefly for a slot, and is shed with 503 if none is freed
+func (api *APIServer) limitConcurrency(next http.HandlerFunc) http.HandlerFunc {
+	if api.expensive == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !api.expensive.Acquire(r.Context()) {
+			api.logger.Warn("Shedding request under load", "path", r.URL.Path)
+			rejectedRequests.Inc("overload")
+			setRetryAfter(w, time.Second)
+			api.writeError(w, r, http.StatusServiceUnavailable, errCodeOverloaded,
+				"too many expensive requests in flight, retry later")
+			return
+		}
+		defer api.expensive.Release()
+
+		expensiveInFlight.Add(1)
+		defer expensiveInFlight.Add(-1)
+
+		next(w, r)
+	}
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, after time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(after.Seconds())), 1)))
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.5")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	server, store := newTestServer(t, nil)
+	commitTestSnapshot(t, store, time.Now().Unix(), map[string]telemetrics.MetricRecord{"sw1": {}})
+
+	resp, body := get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=latency_ms", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	resp, _ = get(t, server.URL+"/telemetry/GetMetric?switch_id=sw1&metric=latency_ms", nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, errCodeRateLimited, resp.Header.Get(errorCodeHeader))
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	// Probes are never rate limited
+	for i := 0; i < 3; i++ {
+		resp, _ = get(t, server.URL+"/livez", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/health"
+)
+
+// readinessCheckTimeout bounds every readiness check, so an unreachable Redis fails the probe
+// instead of hanging it
+const readinessCheckTimeout = 2 * time.Second
+
+// ETLStatus reports the progress of the ETL to the readiness probe
+type ETLStatus interface {
+	// LastSuccess returns the end time of the last successful run, the zero time if none
+	LastSuccess() time.Time
+}
+
+// readinessChecks returns the checks of the readiness probe: the storage can be reached,
+// the ETL succeeded within the freshness threshold and a snapshot was committed
+func (api *APIServer) readinessChecks() []health.Check {
+	return []health.Check{
+		{
+			Name: "storage",
+			Run:  api.dao.Ping,
+		},
+		{
+			Name: "etl",
+			Run: func(ctx context.Context) error {
+				lastSuccess := api.etl.LastSuccess()
+				if lastSuccess.IsZero() {
+					return fmt.Errorf("no successful ETL run yet")
+				}
+				if age := time.Since(lastSuccess); age > api.config.Freshness.MaxAge {
+					return fmt.Errorf("last successful ETL run %s ago", age.Truncate(time.Second))
+				}
+				return nil
+			},
+		},
+		{
+			Name: "snapshot",
+			Run: func(ctx context.Context) error {
+				_, err := api.dao.GetLastUpdateTime(ctx)
+				return err
+			},
+		},
+	}
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+const (
+	// resolutionRaw selects the raw snapshots instead of a rollup tier
+	resolutionRaw = "raw"
+	// resolutionAuto selects the resolution from the requested range
+	resolutionAuto = "auto"
+	// maxAutoPoints is the most points per switch an automatically selected resolution may return
+	maxAutoPoints = 1000
+	// resolutionHeader reports the resolution a response was computed from
+	resolutionHeader = "X-Resolution"
+)
+
+// selectResolution resolves the resolution parameter of a request for the range [from, to]
+// Returns 0 for the raw data, otherwise the resolution of a configured rollup tier
+// In auto mode the finest resolution whose retention covers from and which returns at most
+// maxAutoPoints points is selected, falling back to the coarsest rollup tier
+func (api *APIServer) selectResolution(r *http.Request, from int64, to int64) (time.Duration, error) {
+	tiers := api.config.Retention.Rollups
+
+	switch raw := r.URL.Query().Get("resolution"); raw {
+	case resolutionRaw:
+		return 0, nil
+	case "", resolutionAuto:
+		// Resolved below
+	default:
+		resolution, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid resolution parameter: expected raw, auto or a duration")
+		}
+		for _, tier := range tiers {
+			if tier.Resolution == resolution {
+				return resolution, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown resolution: %s", raw)
+	}
+
+	now := time.Now().Unix()
+
+	// Raw snapshots are one ETL interval apart
+	if fitsResolution(now, from, to, api.config.ETL.Interval, api.config.Retention.Raw) {
+		return 0, nil
+	}
+
+	for _, tier := range tiers {
+		if fitsResolution(now, from, to, tier.Resolution, tier.Retention) {
+			return tier.Resolution, nil
+		}
+	}
+
+	if len(tiers) == 0 {
+		return 0, nil
+	}
+
+	return tiers[len(tiers)-1].Resolution, nil
+}
+
+// fitsResolution reports whether a resolution still holds data from the start of the range
+// and returns a reasonable number of points for it
+func fitsResolution(now int64, from int64, to int64, resolution time.Duration, retention time.Duration) bool {
+	covers := from >= now-int64(retention.Seconds())
+	points := (to - from) / int64(resolution.Seconds())
+	return covers && points <= maxAutoPoints
+}
+
+// formatResolution returns the value of the resolution header
+func formatResolution(resolution time.Duration) string {
+	if resolution == 0 {
+		return resolutionRaw
+	}
+	return resolution.String()
+}
+
+// getRollupSeries retrieves the rollups of a metric at the given resolution within [from, to] grouped
+// by switchID, completed past the last bucket rolled up at that resolution by the finer tiers, then
+// by the raw data
+// The newest buckets are only rolled up once complete and past the rollup delay, and before the first
+// rollup the range is served from the raw data alone
+// Raw points are returned as single-point rollups
+// An empty switchID selects every switch
+func (api *APIServer) getRollupSeries(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.RollupPoint, error) {
+	tiers := api.config.Retention.Rollups
+
+	// Index of the tier of the resolution, coarser tiers are not read
+	tier := len(tiers) - 1
+	for tier >= 0 && tiers[tier].Resolution != resolution {
+		tier--
+	}
+
+	// Each tier is read from the end of the last bucket of the coarser one
+	series := make(map[string][]telemetrics.RollupPoint)
+	tail := from
+	for ; tier >= 0 && tail <= to; tier-- {
+		rollups, err := api.readRollups(ctx, tiers[tier].Resolution, switchID, metric, tail, to)
+		if err != nil {
+			return nil, err
+		}
+
+		next := tail
+		for id, points := range rollups {
+			if len(points) == 0 {
+				continue
+			}
+			series[id] = append(series[id], points...)
+			next = max(next, points[len(points)-1].Timestamp+int64(tiers[tier].Resolution.Seconds()))
+		}
+		tail = next
+	}
+
+	if tail > to {
+		return series, nil
+	}
+
+	raw, err := api.readRaw(ctx, switchID, metric, tail, to)
+	if err != nil {
+		return nil, err
+	}
+	for id, points := range raw {
+		for _, point := range points {
+			series[id] = append(series[id], telemetrics.RollupPoint{
+				Timestamp: point.Timestamp,
+				Min:       point.Value,
+				Max:       point.Value,
+				Avg:       point.Value,
+				Count:     1,
+			})
+		}
+	}
+
+	return series, nil
+}
+
+// readRollups retrieves the rollups of a metric at the given resolution within [from, to] grouped by switchID
+// An empty switchID selects every switch
+func (api *APIServer) readRollups(ctx context.Context,
+	resolution time.Duration,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.RollupPoint, error) {
+	if switchID == "" {
+		return api.dao.GetAllRollupHistory(ctx, resolution, metric, from, to)
+	}
+
+	rollups, err := api.dao.GetRollupHistory(ctx, resolution, switchID, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]telemetrics.RollupPoint{switchID: rollups}, nil
+}
+
+// readRaw retrieves the raw values of a metric within [from, to] grouped by switchID
+// An empty switchID selects every switch
+func (api *APIServer) readRaw(ctx context.Context,
+	switchID string,
+	metric string,
+	from int64,
+	to int64) (map[string][]telemetrics.MetricPoint, error) {
+	if switchID == "" {
+		return api.dao.GetAllMetricHistory(ctx, metric, from, to)
+	}
+
+	points, err := api.dao.GetMetricHistory(ctx, switchID, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]telemetrics.MetricPoint{switchID: points}, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yaron8/telemetry-infra/ingester/aggregate"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+func TestAggregate_BeforeFirstRollup(t *testing.T) {
+	server, store := newTestServer(t, nil)
+	now := time.Now().Unix()
+	commitTestSnapshot(t, store, now-10, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 100}})
+	commitTestSnapshot(t, store, now, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 300}})
+
+	// The window is longer than the raw retention, but no bucket was rolled up yet
+	resp, body := get(t, server.URL+"/telemetry/Aggregate?switch_id=sw1&metric=bandwidth_mbps&window=10m", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "1m0s", resp.Header.Get(resolutionHeader))
+
+	var result map[string]aggregate.Aggregation
+	require.NoError(t, json.Unmarshal(body, &result))
+	assert.Equal(t, 2, result["sw1"].Count)
+	assert.Equal(t, 100.0, result["sw1"].Min)
+	assert.Equal(t, 300.0, result["sw1"].Max)
+	assert.Equal(t, 200.0, result["sw1"].Mean)
+}
+
+func TestAggregate_RollupsFollowedByRawTail(t *testing.T) {
+	ctx := context.Background()
+	server, store := newTestServer(t, nil)
+	now := time.Now().Unix()
+	lastBucket := now - now%60 - 120
+
+	// Two completed buckets were rolled up, the raw data after them is not yet
+	for _, bucket := range []int64{lastBucket - 60, lastBucket} {
+		require.NoError(t, store.AddRollups(ctx, time.Minute, time.Hour, "bandwidth_mbps", map[string]telemetrics.RollupPoint{
+			"sw1": {Timestamp: bucket, Min: 10, Max: 30, Avg: 20, Count: 6},
+			"sw2": {Timestamp: bucket, Min: 1, Max: 1, Avg: 1, Count: 6},
+		}))
+	}
+	// Raw data of the rolled up buckets must not be counted twice
+	commitTestSnapshot(t, store, lastBucket+30, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 25}})
+	commitTestSnapshot(t, store, lastBucket+60, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 40}})
+	commitTestSnapshot(t, store, now, map[string]telemetrics.MetricRecord{"sw1": {BandwidthMbps: 5}, "sw3": {BandwidthMbps: 7}})
+
+	resp, body := get(t, server.URL+"/telemetry/Aggregate?metric=bandwidth_mbps&window=10m", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	var result map[string]aggregate.Aggregation
+	require.NoError(t, json.Unmarshal(body, &result))
+	assert.Equal(t, 14, result["sw1"].Count)
+	assert.Equal(t, 5.0, result["sw1"].Min)
+	assert.Equal(t, 40.0, result["sw1"].Max)
+	assert.InDelta(t, (12*20.0+40+5)/14, result["sw1"].Mean, 1e-9)
+	assert.Equal(t, 12, result["sw2"].Count)
+	assert.Equal(t, 1, result["sw3"].Count)
+
+	resp, body = get(t, server.URL+"/telemetry/GetMetricHistory?switch_id=sw1&metric=bandwidth_mbps&window=10m", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, "1m0s", resp.Header.Get(resolutionHeader))
+
+	var points []telemetrics.MetricPoint
+	require.NoError(t, json.Unmarshal(body, &points))
+	assert.Equal(t, []telemetrics.MetricPoint{
+		{Timestamp: lastBucket - 60, Value: 20},
+		{Timestamp: lastBucket, Value: 20},
+		{Timestamp: lastBucket + 60, Value: 40},
+		{Timestamp: now, Value: 5},
+	}, points)
+}
+
+func TestGetRollupSeries_FinerTiersFillCoarserGap(t *testing.T) {
+	ctx := context.Background()
+	api, store := newTestAPI(t, nil)
+	bucket := int64(3000)
+
+	// The 5m bucket is rolled up, the 1m buckets after it are not yet part of a 5m one
+	require.NoError(t, store.AddRollups(ctx, 5*time.Minute, time.Hour, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": {Timestamp: bucket, Min: 1, Max: 1, Avg: 1, Count: 30},
+	}))
+	require.NoError(t, store.AddRollups(ctx, time.Minute, time.Hour, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": {Timestamp: bucket + 240, Min: 9, Max: 9, Avg: 9, Count: 6},
+	}))
+	require.NoError(t, store.AddRollups(ctx, time.Minute, time.Hour, "latency_ms", map[string]telemetrics.RollupPoint{
+		"sw1": {Timestamp: bucket + 300, Min: 2, Max: 2, Avg: 2, Count: 6},
+	}))
+	commitTestSnapshot(t, store, bucket+200, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 7}})
+	commitTestSnapshot(t, store, bucket+370, map[string]telemetrics.MetricRecord{"sw1": {LatencyMs: 3}})
+
+	series, err := api.getRollupSeries(ctx, 5*time.Minute, "sw1", "latency_ms", bucket, bucket+600)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetrics.RollupPoint{
+		{Timestamp: bucket, Min: 1, Max: 1, Avg: 1, Count: 30},
+		{Timestamp: bucket + 300, Min: 2, Max: 2, Avg: 2, Count: 6},
+		{Timestamp: bucket + 370, Min: 3, Max: 3, Avg: 3, Count: 1},
+	}, series["sw1"])
+}
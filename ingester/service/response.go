@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+)
+
+const (
+	// envelopeVersion is the version of the response envelope, also part of its media type
+	envelopeVersion = 1
+	// contentTypeEnvelope is the media type of enveloped responses, accepted to opt in
+	contentTypeEnvelope = "application/vnd.telemetry.v1+json"
+	// envelopeParamValue is the value of the envelope parameter opting in to the envelope
+	envelopeParamValue = "v1"
+	// requestIDHeader carries the ID of a request, taken from the request or generated
+	requestIDHeader = "X-Request-ID"
+	// errorCodeHeader carries the error code of a failed request, also without the envelope
+	errorCodeHeader = "X-Error-Code"
+	// maxRequestIDLength bounds the length of a request ID taken from the request
+	maxRequestIDLength = 128
+)
+
+// Stable error codes of failed requests
+const (
+	errCodeInvalidParameter = "INVALID_PARAMETER"
+	errCodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	errCodeNoSnapshot       = "NO_SNAPSHOT"
+	errCodeSwitchNotFound   = "SWITCH_NOT_FOUND"
+	errCodeMetricUnknown    = "METRIC_UNKNOWN"
+	errCodeStorageError     = "STORAGE_ERROR"
+	errCodeDataStale        = "DATA_STALE"
+	errCodeUnauthorized     = "UNAUTHORIZED"
+	errCodeForbidden        = "FORBIDDEN"
+	errCodeRateLimited      = "RATE_LIMITED"
+	errCodeOverloaded       = "OVERLOADED"
+)
+
+type requestIDKey struct{}
+
+// responseEnvelope wraps the data or the error of a response with its metadata
+type responseEnvelope struct {
+	Version int            `json:"version"`
+	Data    interface{}    `json:"data,omitempty"`
+	Error   *responseError `json:"error,omitempty"`
+	Meta    responseMeta   `json:"meta"`
+}
+
+// responseError is the error of a failed request, identified by a stable code
+type responseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// responseMeta describes where the data of a response comes from
+// The snapshot fields are omitted if the response is not read from a single snapshot
+type responseMeta struct {
+	SnapshotTimestamp int64  `json:"snapshot_ts,omitempty"`
+	DataAgeSeconds    *int64 `json:"data_age_seconds,omitempty"`
+	Source            string `json:"source"`
+	RequestID         string `json:"request_id"`
+}
+
+// wantsEnvelope reports whether the request opted in to the response envelope, with the
+// envelope=v1 parameter or by accepting the envelope media type
+func wantsEnvelope(r *http.Request) bool {
+	if r.URL.Query().Get("envelope") == envelopeParamValue {
+		return true
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == contentTypeEnvelope {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIDMiddleware assigns every request an ID, taken from its X-Request-ID header if valid
+// The ID is echoed in the response header and available to the handlers through requestID
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned to the request by requestIDMiddleware
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether a request ID sent by a client can be echoed back safely
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// newResponseMeta returns the metadata of a response read from the snapshot of the given timestamp,
+// 0 if the response is not read from a single snapshot
+func (api *APIServer) newResponseMeta(r *http.Request, snapshotTS int64) responseMeta {
+	meta := responseMeta{
+		Source:    api.config.ETL.GeneratorURL,
+		RequestID: requestID(r.Context()),
+	}
+	if snapshotTS > 0 {
+		age := int64(snapshotAge(snapshotTS).Seconds())
+		meta.SnapshotTimestamp = snapshotTS
+		meta.DataAgeSeconds = &age
+	}
+	return meta
+}
+
+// writeJSON writes data as the JSON response of the request with status 200
+// Requests opting in get the data wrapped in the envelope, with the metadata of the snapshot
+// of the given timestamp, 0 if the data is not read from a single snapshot
+func (api *APIServer) writeJSON(w http.ResponseWriter, r *http.Request, snapshotTS int64, data interface{}) {
+	// The envelope may be selected by the Accept header
+	w.Header().Set("Vary", "Accept")
+
+	body := data
+	contentType := contentTypeJSON
+	if wantsEnvelope(r) {
+		body = responseEnvelope{
+			Version: envelopeVersion,
+			Data:    data,
+			Meta:    api.newResponseMeta(r, snapshotTS),
+		}
+		contentType = contentTypeEnvelope
+	}
+
+	// Set content type and status code before encoding
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	// Use encoder to stream JSON directly to response writer
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		// Can't send error response after WriteHeader, just log it
+		api.logger.Error("Error encoding response to JSON", "error", err)
+	}
+}
+
+// writeEnvelopeList streams the entries of a listing in the given JSON format as the data
+// of the envelope, see writeList
+func (api *APIServer) writeEnvelopeList(w io.Writer,
+	r *http.Request,
+	snapshotTS int64,
+	format string,
+	query listQuery,
+	entries []listEntry) error {
+	meta, err := json.Marshal(api.newResponseMeta(r, snapshotTS))
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, `{"version":%d,"meta":%s,"data":`, envelopeVersion, meta); err != nil {
+		return err
+	}
+	if err := writeList(w, format, query, entries); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+// writeError writes a failed response with the given status, error code and message
+// The message is sent as plain text, or as a JSON error in the envelope if the request opted in
+// The error code is also set in the X-Error-Code header
+func (api *APIServer) writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set(errorCodeHeader, code)
+	w.Header().Set("Vary", "Accept")
+
+	if !wantsEnvelope(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeEnvelope)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(responseEnvelope{
+		Version: envelopeVersion,
+		Error: &responseError{
+			Code:    code,
+			Message: message,
+		},
+		Meta: api.newResponseMeta(r, 0),
+	}); err != nil {
+		api.logger.Error("Error encoding error to JSON", "error", err)
+	}
+}
+
+// writeStoreError writes the error of a read from the store
+// Missing snapshots, switches and metrics are reported as 404 with their own code, any other
+// error as a storage error
+func (api *APIServer) writeStoreError(w http.ResponseWriter, r *http.Request, err error, what string) {
+	switch {
+	case errors.Is(err, dao.ErrSnapshotNotFound):
+		api.writeError(w, r, http.StatusNotFound, errCodeNoSnapshot, err.Error())
+	case errors.Is(err, dao.ErrSwitchNotFound):
+		api.writeError(w, r, http.StatusNotFound, errCodeSwitchNotFound, err.Error())
+	case errors.Is(err, dao.ErrMetricNotFound):
+		api.writeError(w, r, http.StatusNotFound, errCodeMetricUnknown, err.Error())
+	case errors.Is(err, dao.ErrInvalidTopK):
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+	default:
+		api.writeError(w, r, http.StatusInternalServerError, errCodeStorageError,
+			fmt.Sprintf("Error retrieving %s: %v", what, err))
+	}
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"context"
+)
+
+// snapshotTime returns the timestamp of the snapshot a request reads from
+// A positive at selects the latest snapshot committed at or before that instant,
+// otherwise the latest committed snapshot is used
+func (api *APIServer) snapshotTime(ctx context.Context, at int64) (int64, error) {
+	if at <= 0 {
+		return api.dao.GetLastUpdateTime(ctx)
+	}
+
+	return api.dao.GetSnapshotTimeAt(ctx, at)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const defaultTopK = 10
+
+// TopKHandler returns the k switches with the highest (order=desc, default) or lowest
+// (order=asc) values of a metric in the latest snapshot, or the one selected by at
+func (api *APIServer) TopKHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("TopKHandler called")
+
+	metricName := r.URL.Query().Get("metric")
+	if metricName == "" {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Missing metric parameter")
+		return
+	}
+
+	k := defaultTopK
+	if rawK := r.URL.Query().Get("k"); rawK != "" {
+		value, err := strconv.Atoi(rawK)
+		if err != nil || value <= 0 {
+			api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid k parameter: expected positive integer")
+			return
+		}
+		k = value
+	}
+
+	order, err := parseOrderParam(r, orderDesc)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	at, err := parseUnixParam(r, "at", 0)
+	if err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+		return
+	}
+
+	timestamp, err := api.snapshotTime(ctx, at)
+	if err != nil {
+		api.logger.Error("Error retrieving snapshot time", "at", at, "error", err)
+		api.writeStoreError(w, r, err, "ranking")
+		return
+	}
+
+	if !api.checkFreshness(w, r, timestamp, at <= 0) {
+		return
+	}
+
+	ranking, err := api.dao.GetTopK(ctx, timestamp, metricName, k, order == orderDesc)
+	if err != nil {
+		api.logger.Error("Error retrieving ranking", "metric", metricName, "error", err)
+		api.writeStoreError(w, r, err, "ranking")
+		return
+	}
+
+	api.writeJSON(w, r, timestamp, ranking)
+}
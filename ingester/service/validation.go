@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validationMiddleware rejects requests to the documented operations whose query parameters
+// do not match the OpenAPI document, with 400 and the reason
+// Parameters that are not documented are ignored, and other paths are passed through
+func (api *APIServer) validationMiddleware(operations []apiOperation, next http.Handler) http.Handler {
+	paramsByPath := make(map[string][]apiParam, len(operations))
+	for _, operation := range operations {
+		paramsByPath[operation.path] = operation.params
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if params, ok := paramsByPath[r.URL.Path]; ok {
+			query := r.URL.Query()
+			for _, param := range params {
+				if err := param.validate(query); err != nil {
+					api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, err.Error())
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate checks the value of the parameter in the query
+func (p apiParam) validate(query url.Values) error {
+	raw := query.Get(p.name)
+	if raw == "" {
+		if p.required {
+			return fmt.Errorf("Missing %s parameter", p.name)
+		}
+		return nil
+	}
+
+	switch p.kind {
+	case paramInteger:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid %s parameter: expected integer", p.name)
+		}
+		if p.minimum != nil && value < *p.minimum {
+			return fmt.Errorf("invalid %s parameter: expected integer of at least %d", p.name, *p.minimum)
+		}
+	case paramTime:
+		if _, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return nil
+		}
+		if _, err := time.Parse(time.RFC3339, raw); err != nil {
+			return fmt.Errorf("invalid %s parameter: expected unix timestamp or RFC3339 time", p.name)
+		}
+	case paramStringList:
+		for _, item := range strings.Split(raw, ",") {
+			if err := p.validateEnum(strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+	default:
+		return p.validateEnum(raw)
+	}
+
+	return nil
+}
+
+// validateEnum checks the value is one of the allowed values of the parameter, if any
+func (p apiParam) validateEnum(value string) error {
+	if len(p.enum) == 0 || slices.Contains(p.enum, value) {
+		return nil
+	}
+	return fmt.Errorf("invalid %s parameter: %q is not one of %s", p.name, value, strings.Join(p.enum, ", "))
+}
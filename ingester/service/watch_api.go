@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/yaron8/telemetry-infra/ingester/dao"
+	"github.com/yaron8/telemetry-infra/instrument"
+	"github.com/yaron8/telemetry-infra/telemetrics"
+)
+
+// watchKeepAlive is how often a comment is sent on an idle stream, so proxies keep it open
+const watchKeepAlive = 15 * time.Second
+
+var watchSubscribers = instrument.NewGaugeVec("ingester_watch_subscribers",
+	"Clients currently connected to the Watch stream.")
+
+// watchEvent is the data of a snapshot event
+type watchEvent struct {
+	Timestamp int64                         `json:"timestamp"`
+	Switches  map[string]map[string]float64 `json:"switches"`
+}
+
+// WatchHandler streams a Server-Sent Event every time a new snapshot is committed
+// The current snapshot is sent on connect, unless the Last-Event-ID header shows the client has it
+// Switches are filtered by the switch_id glob and prefix parameters, and metrics by the
+// repeated metric parameter, every metric being sent if none is requested
+func (api *APIServer) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	api.logger.Info("WatchHandler called")
+
+	filter := dao.SwitchFilter{
+		Prefix:  r.URL.Query().Get("prefix"),
+		Pattern: r.URL.Query().Get("switch_id"),
+	}
+	if err := filter.Validate(); err != nil {
+		api.writeError(w, r, http.StatusBadRequest, errCodeInvalidParameter, fmt.Sprintf("invalid switch_id parameter: %v", err))
+		return
+	}
+
+	metrics := r.URL.Query()["metric"]
+	for _, metric := range metrics {
+		if !slices.Contains(telemetrics.GetMetricNames(), metric) {
+			api.writeError(w, r, http.StatusNotFound, errCodeMetricUnknown, dao.ErrMetricNotFound.Error())
+			return
+		}
+	}
+	if len(metrics) == 0 {
+		metrics = telemetrics.GetMetricNames()
+	}
+
+	// The stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		api.logger.Error("Error disabling write deadline", "error", err)
+	}
+
+	// Subscribe before reading the current snapshot, so no commit is missed in between
+	commits, unsubscribe := api.broker.Subscribe()
+	defer unsubscribe()
+
+	watchSubscribers.Add(1)
+	defer watchSubscribers.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		api.logger.Error("Error flushing stream", "error", err)
+		return
+	}
+
+	lastSent, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if timestamp, err := api.dao.GetLastUpdateTime(ctx); err == nil && timestamp > lastSent {
+		if err := api.sendSnapshotEvent(ctx, rc, w, timestamp, filter, metrics); err != nil {
+			api.logger.Error("Error sending snapshot event", "timestamp", timestamp, "error", err)
+			return
+		}
+		lastSent = timestamp
+	}
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-api.shuttingDown:
+			// Clients reconnect to another instance with Last-Event-ID
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case timestamp := <-commits:
+			if timestamp <= lastSent {
+				continue
+			}
+			if err := api.sendSnapshotEvent(ctx, rc, w, timestamp, filter, metrics); err != nil {
+				api.logger.Error("Error sending snapshot event", "timestamp", timestamp, "error", err)
+				return
+			}
+			lastSent = timestamp
+		}
+	}
+}
+
+// sendSnapshotEvent writes the selected switches and metrics of the snapshot as a single event
+func (api *APIServer) sendSnapshotEvent(ctx context.Context,
+	rc *http.ResponseController,
+	w http.ResponseWriter,
+	timestamp int64,
+	filter dao.SwitchFilter,
+	metrics []string) error {
+	allKeysAndMetrics, err := api.dao.GetAll(ctx, timestamp, filter)
+	if err != nil {
+		return fmt.Errorf("error retrieving metrics: %w", err)
+	}
+
+	event := watchEvent{
+		Timestamp: timestamp,
+		Switches:  make(map[string]map[string]float64, len(allKeysAndMetrics)),
+	}
+	for _, keyAndMetric := range allKeysAndMetrics {
+		for switchID, record := range keyAndMetric {
+			values := make(map[string]float64, len(metrics))
+			for _, metric := range metrics {
+				values[metric], _ = record.GetMetricValue(metric)
+			}
+			event.Switches[switchID] = values
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event to JSON: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(w, "event: snapshot\nid: %d\ndata: %s\n\n", timestamp, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
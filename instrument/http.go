@@ -0,0 +1,70 @@
+package instrument
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounterVec("http_requests_total",
+		"Total HTTP requests by route and status code.", "route", "status")
+	httpRequestDuration = NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency in seconds by route and status code.", DefBuckets, "route", "status")
+	httpRequestsInFlight = NewGaugeVec("http_requests_in_flight",
+		"HTTP requests currently being served.")
+)
+
+// Middleware records the count, latency and in-flight number of the requests served by next
+// Requests are labeled by the ServeMux pattern they matched, so the label cardinality is bounded
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequestsInFlight.Add(1)
+		defer httpRequestsInFlight.Add(-1)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// The ServeMux sets the matched pattern on the request while routing it
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(recorder.status)
+		httpRequests.Inc(route, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, status)
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if !sr.wroteHeader {
+		sr.status = code
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(data)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
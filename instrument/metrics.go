@@ -0,0 +1,179 @@
+package instrument
+
+import (
+	"sync"
+
+	"github.com/yaron8/telemetry-infra/promtext"
+)
+
+// CounterVec is a family of monotonically increasing counters partitioned by labels
+type CounterVec struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter family and registers it for exposition
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{
+		family: family{name: name, help: help, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+	if len(labelNames) == 0 {
+		// An unlabeled counter is exposed from the start
+		c.values[""] = 0
+	}
+	defaultRegistry.register(c)
+	return c
+}
+
+// Inc increments the counter of the given label values by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter of the given label values by delta, which must not be negative
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] += delta
+}
+
+func (c *CounterVec) write(pw *promtext.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pw.Family(c.name, c.help, promtext.TypeCounter)
+	for _, key := range sortedKeys(c.values) {
+		pw.Sample(c.name, c.labels(splitKey(key, len(c.labelNames))), c.values[key], 0)
+	}
+}
+
+// GaugeVec is a family of values that can go up and down, partitioned by labels
+type GaugeVec struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec creates a gauge family and registers it for exposition
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{
+		family: family{name: name, help: help, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+	if len(labelNames) == 0 {
+		// An unlabeled gauge is exposed from the start
+		g.values[""] = 0
+	}
+	defaultRegistry.register(g)
+	return g
+}
+
+// Set sets the gauge of the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[key] = value
+}
+
+// Add adds delta, which may be negative, to the gauge of the given label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	key := g.key(labelValues)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[key] += delta
+}
+
+func (g *GaugeVec) write(pw *promtext.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pw.Family(g.name, g.help, promtext.TypeGauge)
+	for _, key := range sortedKeys(g.values) {
+		pw.Sample(g.name, g.labels(splitKey(key, len(g.labelNames))), g.values[key], 0)
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // Non-cumulative count per bucket
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram family with the given ascending bucket upper bounds
+// and registers it for exposition
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{
+		family:  family{name: name, help: help, labelNames: labelNames},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	if len(labelNames) == 0 {
+		// An unlabeled histogram is exposed from the start
+		h.series[""] = &histogram{counts: make([]uint64, len(buckets))}
+	}
+	defaultRegistry.register(h)
+	return h
+}
+
+// Observe records a value in the histogram of the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(pw *promtext.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pw.Family(h.name, h.help, promtext.TypeHistogram)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		labelValues := splitKey(key, len(h.labelNames))
+
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := promtext.Label{Name: "le", Value: promtext.FormatValue(bound)}
+			pw.Sample(h.name+"_bucket", h.labels(labelValues, le), float64(cumulative), 0)
+		}
+		inf := promtext.Label{Name: "le", Value: "+Inf"}
+		pw.Sample(h.name+"_bucket", h.labels(labelValues, inf), float64(s.count), 0)
+		pw.Sample(h.name+"_sum", h.labels(labelValues), s.sum, 0)
+		pw.Sample(h.name+"_count", h.labels(labelValues), float64(s.count), 0)
+	}
+}
@@ -0,0 +1,93 @@
+package instrument
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yaron8/telemetry-infra/promtext"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to request and command latencies
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric family that renders itself in the Prometheus text format
+type collector interface {
+	write(pw *promtext.Writer)
+}
+
+// Registry holds the metric families of a process
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+var defaultRegistry = &Registry{}
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.collectors = append(reg.collectors, c)
+}
+
+// Handler renders every metric family of the process in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultRegistry.mu.Lock()
+		collectors := append([]collector(nil), defaultRegistry.collectors...)
+		defaultRegistry.mu.Unlock()
+
+		w.Header().Set("Content-Type", promtext.ContentType)
+		w.WriteHeader(http.StatusOK)
+
+		pw := promtext.NewWriter(w)
+		for _, c := range collectors {
+			c.write(pw)
+		}
+		_ = pw.Flush()
+	})
+}
+
+// family holds the description and label names shared by the series of a metric
+type family struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+// key joins the label values of a series into a map key
+// It panics if the number of values does not match the label names, as that is a programming error
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic("instrument: " + f.name + " expects labels " + strings.Join(f.labelNames, ","))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (f *family) labels(labelValues []string, extra ...promtext.Label) []promtext.Label {
+	labels := make([]promtext.Label, 0, len(labelValues)+len(extra))
+	for i, value := range labelValues {
+		labels = append(labels, promtext.Label{Name: f.labelNames[i], Value: value})
+	}
+	return append(labels, extra...)
+}
+
+// sortedKeys returns the keys of the series map sorted, so every scrape renders in the same order
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitKey returns the label values joined by key
+func splitKey(key string, labelCount int) []string {
+	if labelCount == 0 {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
@@ -9,8 +9,9 @@ import (
 )
 
 var (
-	logger *slog.Logger
-	once   sync.Once
+	logger  *slog.Logger
+	logFile *os.File
+	once    sync.Once
 )
 
 // Config holds the logging configuration
@@ -80,6 +81,7 @@ func NewLog(cfg *Config) (*slog.Logger, error) {
 
 		handler := slog.NewJSONHandler(file, opts)
 		logger = slog.New(handler)
+		logFile = file
 
 		// Log initialization
 		logger.Info("logger initialized",
@@ -105,6 +107,18 @@ func GetLogger() *slog.Logger {
 	return logger
 }
 
+// Close flushes the log file to disk and closes it, call it last on shutdown
+// Records logged afterwards are dropped
+func Close() error {
+	if logFile == nil {
+		return nil
+	}
+	if err := logFile.Sync(); err != nil {
+		return fmt.Errorf("failed to flush log file: %w", err)
+	}
+	return logFile.Close()
+}
+
 // isDirWritable checks if a directory is writable
 func isDirWritable(path string) bool {
 	// Try to create the directory first
@@ -0,0 +1,113 @@
+package promtext
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
+)
+
+// Label is a single name="value" pair of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer renders metric families in the Prometheus text exposition format
+// Errors are sticky: after the first failed write every call is a no-op and Err returns it
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter creates a new Writer buffering its output to w
+// Flush must be called once every family has been written
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family writes the HELP and TYPE lines starting a metric family
+func (pw *Writer) Family(name string, help string, metricType string) {
+	pw.write("# HELP ", name, " ", escapeHelp(help), "\n")
+	pw.write("# TYPE ", name, " ", metricType, "\n")
+}
+
+// Sample writes a single sample of the current family
+// A timestampMs of 0 omits the timestamp, so the scrape time is used
+func (pw *Writer) Sample(name string, labels []Label, value float64, timestampMs int64) {
+	pw.write(name)
+	if len(labels) > 0 {
+		pw.write("{")
+		for i, label := range labels {
+			if i > 0 {
+				pw.write(",")
+			}
+			pw.write(label.Name, `="`, escapeLabelValue(label.Value), `"`)
+		}
+		pw.write("}")
+	}
+	pw.write(" ", FormatValue(value))
+	if timestampMs != 0 {
+		pw.write(" ", strconv.FormatInt(timestampMs, 10))
+	}
+	pw.write("\n")
+}
+
+// Flush writes any buffered output and returns the first error encountered
+func (pw *Writer) Flush() error {
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	return pw.err
+}
+
+// Err returns the first error encountered
+func (pw *Writer) Err() error {
+	return pw.err
+}
+
+func (pw *Writer) write(parts ...string) {
+	for _, part := range parts {
+		if pw.err != nil {
+			return
+		}
+		_, pw.err = pw.w.WriteString(part)
+	}
+}
+
+// FormatValue formats a sample value, spelling out the special float values
+func FormatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -8,6 +8,66 @@ type MetricRecord struct {
 	PacketErrors  int     `json:"packet_errors"`
 }
 
+// MetricPoint is a single value of a metric at a given snapshot timestamp
+type MetricPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// RollupPoint summarizes the values of a metric within a time bucket starting at Timestamp
+type RollupPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Avg       float64 `json:"avg"`
+	Count     int     `json:"count"`
+}
+
+// RankedSwitch is the value of a metric for a switch within a snapshot ranking
+type RankedSwitch struct {
+	SwitchID string  `json:"switch_id"`
+	Value    float64 `json:"value"`
+}
+
+// GetMetricValue returns the numeric value of the given metric name
+// The second return value is false if the metric name is unknown
+func (r MetricRecord) GetMetricValue(metric string) (float64, bool) {
+	switch metric {
+	case "bandwidth_mbps":
+		return r.BandwidthMbps, true
+	case "latency_ms":
+		return r.LatencyMs, true
+	case "packet_errors":
+		return float64(r.PacketErrors), true
+	default:
+		return 0, false
+	}
+}
+
+// SetMetricValue sets the value of the given metric name
+// Returns false if the metric name is unknown
+func (r *MetricRecord) SetMetricValue(metric string, value float64) bool {
+	switch metric {
+	case "bandwidth_mbps":
+		r.BandwidthMbps = value
+	case "latency_ms":
+		r.LatencyMs = value
+	case "packet_errors":
+		r.PacketErrors = int(value)
+	default:
+		return false
+	}
+	return true
+}
+
+// GetMetricNames returns the names of the numeric metrics of a MetricRecord
+func GetMetricNames() []string {
+	return []string{
+		"bandwidth_mbps",
+		"latency_ms",
+		"packet_errors"}
+}
+
 func GetCSVHeader() []string {
 	return []string{
 		"timestamp",